@@ -0,0 +1,565 @@
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+// Converts a Go value into the equivalent Lox value:
+//
+//   - nil, nil pointers and nil functions become nil
+//   - bools and strings become bools and strings
+//   - integers of any kind (including *big.Int) become integers, and floats
+//     become floats
+//   - slices and arrays become lists
+//   - maps become maps, as long as their keys convert to hashable values
+//   - structs become maps from field names to values; only exported fields
+//     are included, and a `lox:"name"` tag renames a field (or skips it, if
+//     the name is "-")
+//   - pointers become whatever they point to
+//   - functions are wrapped using WrapGoFunc
+//   - host objects are wrapped using NewHost
+//   - Lox values are returned unchanged
+//
+// Anything else, such as a channel, is an error.
+func FromGo(x interface{}) (Value, error) {
+	if x == nil {
+		return NewNil(), nil
+	}
+	return fromGo(reflect.ValueOf(x), "", map[goReference]bool{})
+}
+
+// A pointer, map or slice that fromGo is in the middle of converting
+type goReference struct {
+	ty     reflect.Type
+	ptr    uintptr
+	length int
+}
+
+// Converts a Go value, naming it name if it is a function that has to be
+// wrapped. Reports an error rather than recursing forever if the value
+// contains itself via any of the references being converted.
+func fromGo(v reflect.Value, name string, converting map[goReference]bool) (Value, error) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NewNil(), nil
+		}
+	}
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case Value:
+			return x, nil
+		case *big.Int:
+			return NewBigInteger(x), nil
+		case HostObject:
+			return NewHost(x), nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		ref := goReference{ty: v.Type(), ptr: v.Pointer(), length: 0}
+		if v.Kind() == reflect.Slice {
+			ref.length = v.Len()
+		}
+		if ref.ptr != 0 {
+			if converting[ref] {
+				return nil, fmt.Errorf("cannot convert cyclic Go %v to a Lox value", v.Type())
+			}
+			converting[ref] = true
+			defer delete(converting, ref)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return fromGo(v.Elem(), name, converting)
+	case reflect.Bool:
+		return NewBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return bigInteger(new(big.Int).SetUint64(v.Uint())), nil
+		}
+		return NewInteger(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return NewNumber(v.Float()), nil
+	case reflect.String:
+		return NewString(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]Value, v.Len())
+		for i := range elements {
+			element, err := fromGo(v.Index(i), "", converting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return NewList(elements), nil
+	case reflect.Map:
+		m := NewMap()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromGo(iter.Key(), "", converting)
+			if err != nil {
+				return nil, err
+			}
+			keyName := ""
+			if str, ok := key.(String); ok {
+				keyName = str.value
+			}
+			value, err := fromGo(iter.Value(), keyName, converting)
+			if err != nil {
+				return nil, err
+			}
+			if !m.Insert(key, value) {
+				return nil, fmt.Errorf("%v values cannot be used as map keys", key.Type())
+			}
+		}
+		return m, nil
+	case reflect.Struct:
+		m := NewMap()
+		for i := 0; i < v.NumField(); i++ {
+			fieldName, ok := structFieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			value, err := fromGo(v.Field(i), fieldName, converting)
+			if err != nil {
+				return nil, err
+			}
+			m.Insert(NewString(fieldName), value)
+		}
+		return m, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NewNil(), nil
+		}
+		if name == "" {
+			name = goFuncName(v)
+		}
+		return wrapGoFunc(v, name), nil
+	default:
+		return nil, fmt.Errorf("cannot convert Go %v to a Lox value", v.Type())
+	}
+}
+
+// Returns the name a struct field is converted to and from, or false if
+// the field is unexported or tagged to be skipped
+func structFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("lox")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+// Converts a Lox value into a Go value, storing it in the variable that ptr
+// points to. This is the reverse of FromGo, except that:
+//
+//   - numbers only convert to integer types if they are in range, and
+//     floats only if they are also whole
+//   - structs can be filled in from instances as well as maps
+//   - host objects are unwrapped if the target can hold them
+//   - functions cannot be converted, since calling them needs an interpreter
+//   - lists, maps and instances that contain themselves cannot be converted
+//
+// When the target is an empty interface, the value is converted to the
+// closest plain Go type: nil, bool, int64 (or *big.Int if it does not fit),
+// float64, string, []interface{}, and
+// map[string]interface{} (or map[interface{}]interface{} if any of the keys
+// are not strings), or the wrapped object for host objects. Other values,
+// such as functions and instances, are stored as the Lox value itself.
+func ToGo(value Value, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("ToGo requires a non-nil pointer")
+	}
+	return toGo(value, v.Elem(), map[Value]bool{})
+}
+
+// Converts a Lox value into v. Reports an error rather than recursing forever
+// if the value is a list, map or instance that contains itself via any of the
+// ones being converted.
+func toGo(value Value, v reflect.Value, converting map[Value]bool) error {
+	switch value.(type) {
+	case *List, *Map, *Instance:
+		if converting[value] {
+			return fmt.Errorf("cannot convert cyclic %v to Go %v", value.Type(), v.Type())
+		}
+		converting[value] = true
+		defer delete(converting, value)
+	}
+	return toGoValue(value, v, converting)
+}
+
+func toGoValue(value Value, v reflect.Value, converting map[Value]bool) error {
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		x, err := toGoInterface(value, converting)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+	if reflect.TypeOf(value).AssignableTo(v.Type()) {
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+	if host, ok := value.(*Host); ok && reflect.TypeOf(host.object).AssignableTo(v.Type()) {
+		v.Set(reflect.ValueOf(host.object))
+		return nil
+	}
+	if b, ok := toBig(value); ok && v.Type() == bigIntType {
+		v.Set(reflect.ValueOf(new(big.Int).Set(b)))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		x, ok := value.(Bool)
+		if !ok {
+			return mismatchError(value, v.Type())
+		}
+		v.SetBool(x.value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch x := value.(type) {
+		case Integer:
+			if v.OverflowInt(x.value) {
+				return fmt.Errorf("%v cannot be represented as Go %v", x, v.Type())
+			}
+			n = x.value
+		case BigInteger:
+			return fmt.Errorf("%v cannot be represented as Go %v", x, v.Type())
+		case Number:
+			if x.value != math.Trunc(x.value) || x.value < math.MinInt64 ||
+				x.value >= math.MaxInt64 || v.OverflowInt(int64(x.value)) {
+				return fmt.Errorf("%v cannot be represented as Go %v", x, v.Type())
+			}
+			n = int64(x.value)
+		default:
+			return mismatchError(value, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := value.(type) {
+		case Integer, BigInteger:
+			b, _ := toBig(x)
+			if !b.IsUint64() || v.OverflowUint(b.Uint64()) {
+				return fmt.Errorf("%v cannot be represented as Go %v", x, v.Type())
+			}
+			n = b.Uint64()
+		case Number:
+			if x.value != math.Trunc(x.value) || x.value < 0 ||
+				x.value >= math.MaxUint64 || v.OverflowUint(uint64(x.value)) {
+				return fmt.Errorf("%v cannot be represented as Go %v", x, v.Type())
+			}
+			n = uint64(x.value)
+		default:
+			return mismatchError(value, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := value.(type) {
+		case Integer, BigInteger, Number:
+			v.SetFloat(toFloat(x))
+		default:
+			return mismatchError(value, v.Type())
+		}
+	case reflect.String:
+		x, ok := value.(String)
+		if !ok {
+			return mismatchError(value, v.Type())
+		}
+		v.SetString(x.value)
+	case reflect.Slice:
+		if value.Type() == TypeNil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		x, ok := value.(*List)
+		if !ok {
+			return mismatchError(value, v.Type())
+		}
+		slice := reflect.MakeSlice(v.Type(), len(x.elements), len(x.elements))
+		for i, element := range x.elements {
+			err := toGo(element, slice.Index(i), converting)
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		x, ok := value.(*List)
+		if !ok {
+			return mismatchError(value, v.Type())
+		}
+		if len(x.elements) != v.Len() {
+			return fmt.Errorf(
+				"cannot convert list of length %d to Go %v",
+				len(x.elements),
+				v.Type(),
+			)
+		}
+		for i, element := range x.elements {
+			err := toGo(element, v.Index(i), converting)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type() == TypeNil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		x, ok := value.(*Map)
+		if !ok {
+			return mismatchError(value, v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), x.count)
+		for _, entry := range x.order {
+			if entry.deleted {
+				continue
+			}
+			key := reflect.New(v.Type().Key()).Elem()
+			err := toGo(entry.key, key, converting)
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			err = toGo(entry.value, elem, converting)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		var fields map[string]Value
+		switch x := value.(type) {
+		case *Map:
+			fields = map[string]Value{}
+			for _, entry := range x.order {
+				if entry.deleted {
+					continue
+				}
+				name, ok := entry.key.(String)
+				if !ok {
+					return fmt.Errorf(
+						"cannot convert map with %v keys to Go %v",
+						entry.key.Type(),
+						v.Type(),
+					)
+				}
+				fields[name.value] = entry.value
+			}
+		case *Instance:
+			fields = x.fields
+		default:
+			return mismatchError(value, v.Type())
+		}
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := structFieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			field, ok := fields[name]
+			if !ok {
+				continue
+			}
+			err := toGo(field, v.Field(i), converting)
+			if err != nil {
+				return fmt.Errorf("field '%s': %v", name, err)
+			}
+		}
+	case reflect.Ptr:
+		if value.Type() == TypeNil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		// The value is already marked as being converted
+		err := toGoValue(value, elem.Elem(), converting)
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+	default:
+		return mismatchError(value, v.Type())
+	}
+	return nil
+}
+
+func mismatchError(value Value, ty reflect.Type) error {
+	return fmt.Errorf("cannot convert %v to Go %v", value.Type(), ty)
+}
+
+func toGoInterface(value Value, converting map[Value]bool) (interface{}, error) {
+	switch x := value.(type) {
+	case Nil:
+		return nil, nil
+	case Bool:
+		return x.value, nil
+	case Integer:
+		return x.value, nil
+	case BigInteger:
+		return x.Big(), nil
+	case Number:
+		return x.value, nil
+	case String:
+		return x.value, nil
+	case *List:
+		elements := make([]interface{}, len(x.elements))
+		for i, element := range x.elements {
+			err := toGo(element, reflect.ValueOf(elements).Index(i), converting)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	case *Host:
+		return x.object, nil
+	case *Map:
+		stringKeys := true
+		for _, entry := range x.order {
+			if _, ok := entry.key.(String); !ok && !entry.deleted {
+				stringKeys = false
+			}
+		}
+		var m reflect.Value
+		if stringKeys {
+			m = reflect.ValueOf(map[string]interface{}{})
+		} else {
+			m = reflect.ValueOf(map[interface{}]interface{}{})
+		}
+		for _, entry := range x.order {
+			if entry.deleted {
+				continue
+			}
+			key := reflect.New(m.Type().Key()).Elem()
+			err := toGo(entry.key, key, converting)
+			if err != nil {
+				return nil, err
+			}
+			elem := reflect.New(m.Type().Elem()).Elem()
+			err = toGo(entry.value, elem, converting)
+			if err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		return m.Interface(), nil
+	default:
+		return value, nil
+	}
+}
+
+// Turns a Go function into a native function. Its arguments are converted
+// from Lox values using ToGo and its result is converted back using
+// FromGo; a wrong argument is reported as a runtime error, and so is a
+// non-nil error if the function returns one as its last result. The
+// function may return nothing, a single value, an error, or a value and an
+// error. If the function is variadic, so is the native fn.
+//
+// The native fn is named after the Go function, unless it is an anonymous
+// function, in which case so is the native fn until it is given a name by
+// Interpreter.SetGlobal.
+//
+// Panics if fn is not a function with such a signature.
+func WrapGoFunc(fn interface{}) *NativeFn {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic(fmt.Sprintf("WrapGoFunc: %T is not a function", fn))
+	}
+	return wrapGoFunc(v, goFuncName(v))
+}
+
+// Returns the unqualified name of a Go function, which is fine for error
+// messages but not guaranteed to be unique, or "" for function literals
+func goFuncName(v reflect.Value) string {
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return ""
+	}
+	name := f.Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	if strings.HasPrefix(name, "func") && strings.Trim(name[len("func"):], "0123456789") == "" {
+		return ""
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func wrapGoFunc(v reflect.Value, name string) *NativeFn {
+	ty := v.Type()
+	minArity, maxArity := ty.NumIn(), ty.NumIn()
+	if ty.IsVariadic() {
+		minArity, maxArity = ty.NumIn()-1, -1
+	}
+	returnsError := ty.NumOut() > 0 && ty.Out(ty.NumOut()-1) == errorType
+	if ty.NumOut() > 2 || (ty.NumOut() == 2 && !returnsError) {
+		panic(fmt.Sprintf("WrapGoFunc: results of %v are not supported", ty))
+	}
+
+	return NewVariadicNativeFn(minArity, maxArity, name, func(args []Value) (Value, RuntimeException) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var argType reflect.Type
+			if ty.IsVariadic() && i >= minArity {
+				argType = ty.In(minArity).Elem()
+			} else {
+				argType = ty.In(i)
+			}
+			in[i] = reflect.New(argType).Elem()
+			err := toGo(arg, in[i], map[Value]bool{})
+			if err != nil {
+				return nil, NewRuntimeError(
+					Token{},
+					fmt.Sprintf("argument %d: %v", i+1, err),
+				)
+			}
+		}
+
+		out := v.Call(in)
+		if returnsError {
+			err := out[len(out)-1]
+			if !err.IsNil() {
+				return nil, NewRuntimeError(Token{}, err.Interface().(error).Error())
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return NewNil(), nil
+		}
+
+		result, err := fromGo(out[0], "", map[goReference]bool{})
+		if err != nil {
+			return nil, NewRuntimeError(
+				Token{},
+				fmt.Sprintf("result: %v", err),
+			)
+		}
+		return result, nil
+	})
+}
@@ -0,0 +1,330 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bridgePoint struct {
+	X      int
+	Y      float64
+	Label  string `lox:"label"`
+	Hidden string `lox:"-"`
+	Tags   []string
+	Extra  map[string]int
+}
+
+func TestFromGoToGoRoundTrip(t *testing.T) {
+	values := []interface{}{
+		true,
+		int64(-3),
+		uint8(200),
+		2.5,
+		"héllo",
+		[]int{1, 2, 3},
+		map[string]bool{"a": true, "b": false},
+		bridgePoint{X: 1, Y: 2.5, Label: "p", Tags: []string{"x"}, Extra: map[string]int{"z": 26}},
+	}
+	for _, value := range values {
+		converted, err := FromGo(value)
+		if err != nil {
+			t.Errorf("FromGo(%#v): %v", value, err)
+			continue
+		}
+		back := reflect.New(reflect.TypeOf(value))
+		if err := ToGo(converted, back.Interface()); err != nil {
+			t.Errorf("ToGo(%v) into %T: %v", converted, value, err)
+			continue
+		}
+		if !reflect.DeepEqual(back.Elem().Interface(), value) {
+			t.Errorf("%#v became %v and then %#v", value, converted, back.Elem().Interface())
+		}
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	huge := new(big.Int).Lsh(big.NewInt(1), 100)
+	tests := []struct {
+		value interface{}
+		repr  string
+	}{
+		{nil, "nil"},
+		{(*int)(nil), "nil"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{huge, "1267650600228229401496703205376"},
+		{[2]string{"a", "b"}, `["a", "b"]`},
+		{bridgePoint{X: 1, Label: "p"}, `{"X": 1, "Y": 0.0, "label": "p", "Tags": [], "Extra": {}}`},
+		{&bridgePoint{}, `{"X": 0, "Y": 0.0, "label": "", "Tags": [], "Extra": {}}`},
+		{NewString("lox"), `"lox"`},
+	}
+	for _, test := range tests {
+		value, err := FromGo(test.value)
+		if err != nil {
+			t.Errorf("FromGo(%#v): %v", test.value, err)
+		} else if value.Repr() != test.repr {
+			t.Errorf("FromGo(%#v) = %s, want %s", test.value, value.Repr(), test.repr)
+		}
+	}
+}
+
+type bridgeNode struct {
+	Next *bridgeNode
+}
+
+func TestFromGoErrors(t *testing.T) {
+	cyclic := &bridgeNode{}
+	cyclic.Next = cyclic
+	selfSlice := []interface{}{nil}
+	selfSlice[0] = selfSlice
+	selfMap := map[string]interface{}{}
+	selfMap["self"] = selfMap
+
+	for _, value := range []interface{}{cyclic, selfSlice, selfMap} {
+		if _, err := FromGo(value); err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("FromGo(%T) = %v, want a cyclic value error", value, err)
+		}
+	}
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Errorf("FromGo(chan int) succeeded")
+	}
+
+	// The same value twice is fine as long as it does not contain itself
+	shared := &bridgeNode{}
+	if _, err := FromGo([]*bridgeNode{shared, shared}); err != nil {
+		t.Errorf("FromGo of a shared pointer: %v", err)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	var x interface{}
+	list := NewList([]Value{NewInteger(1), NewString("a"), NewNil()})
+	if err := ToGo(list, &x); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(1), "a", nil}; !reflect.DeepEqual(x, want) {
+		t.Errorf("got %#v, want %#v", x, want)
+	}
+
+	var n int8
+	if err := ToGo(NewInteger(1000), &n); err == nil {
+		t.Errorf("converting 1000 to int8 succeeded with %d", n)
+	}
+	var s string
+	if err := ToGo(NewInteger(1), &s); err == nil {
+		t.Errorf("converting an integer to a string succeeded")
+	}
+	if err := ToGo(NewInteger(1), s); err == nil {
+		t.Errorf("converting into a non-pointer succeeded")
+	}
+}
+
+func TestToGoCycles(t *testing.T) {
+	list := NewList([]Value{NewInteger(1)})
+	list.elements = append(list.elements, list)
+	loop := NewList([]Value{})
+	loop.elements = append(loop.elements, loop)
+	m := NewMap()
+	m.Insert(NewString("self"), NewList([]Value{m}))
+	class := NewClass(nil, "Node", nil, map[string]Method{})
+	node := NewInstance(class)
+	node.fields["Next"] = node
+
+	var x interface{}
+	var nested [][]interface{}
+	var n bridgeNode
+	tests := []struct {
+		value Value
+		ptr   interface{}
+	}{
+		{list, &x},
+		{loop, &nested},
+		{m, &x},
+		{node, &n},
+	}
+	for _, test := range tests {
+		err := ToGo(test.value, test.ptr)
+		if err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("ToGo(%v, %T) = %v, want a cyclic value error", test.value, test.ptr, err)
+		}
+	}
+
+	// The same value twice is fine as long as it does not contain itself
+	shared := NewList([]Value{NewInteger(1)})
+	if err := ToGo(NewList([]Value{shared, shared}), &nested); err != nil {
+		t.Errorf("ToGo of a shared list: %v", err)
+	}
+}
+
+func TestToGoConversions(t *testing.T) {
+	class := NewClass(nil, "Point", nil, map[string]Method{})
+	point := NewInstance(class)
+	point.fields["X"] = NewInteger(1)
+	point.fields["Y"] = NewNumber(2.5)
+	point.fields["label"] = NewString("p")
+	point.fields["Hidden"] = NewString("h")
+	fields := NewMap()
+	fields.Insert(NewString("X"), NewNumber(3))
+	fields.Insert(NewString("Tags"), NewList([]Value{NewString("t")}))
+
+	var p bridgePoint
+	if err := ToGo(point, &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := (bridgePoint{X: 1, Y: 2.5, Label: "p"}); !reflect.DeepEqual(p, want) {
+		t.Errorf("got %#v from an instance, want %#v", p, want)
+	}
+	var pp *bridgePoint
+	if err := ToGo(fields, &pp); err != nil {
+		t.Fatal(err)
+	}
+	if want := (bridgePoint{X: 3, Tags: []string{"t"}}); pp == nil || !reflect.DeepEqual(*pp, want) {
+		t.Errorf("got %#v from a map, want %#v", pp, want)
+	}
+	if err := ToGo(NewNil(), &pp); err != nil || pp != nil {
+		t.Errorf("converting nil to a pointer gave %v, %v", pp, err)
+	}
+
+	var u uint8
+	var i int
+	var f float32
+	var b *big.Int
+	var array [2]int
+	huge := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 100))
+	tests := []struct {
+		value Value
+		ptr   interface{}
+		err   string
+	}{
+		{NewInteger(255), &u, ""},
+		{NewInteger(-1), &u, "-1 cannot be represented as Go uint8"},
+		{NewNumber(4), &i, ""},
+		{NewNumber(4.5), &i, "4.5 cannot be represented as Go int"},
+		{huge, &i, "cannot be represented as Go int"},
+		{huge, &b, ""},
+		{huge, &f, ""},
+		{NewList([]Value{NewInteger(1), NewInteger(2)}), &array, ""},
+		{NewList([]Value{NewInteger(1)}), &array, "cannot convert list of length 1 to Go [2]int"},
+		{NewList([]Value{NewString("a")}), &[]int{}, "cannot convert string to Go int"},
+		{point, &map[string]int{}, "cannot convert instance to Go map[string]int"},
+		{fields, &map[string]int{}, "cannot convert list to Go int"},
+		{NewMap(), &p, ""},
+	}
+	for _, test := range tests {
+		err := ToGo(test.value, test.ptr)
+		if test.err == "" && err != nil {
+			t.Errorf("ToGo(%v, %T): %v", test.value, test.ptr, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("ToGo(%v, %T) = %v, want an error containing %q", test.value, test.ptr, err, test.err)
+		}
+	}
+	if b.Cmp(new(big.Int).Lsh(big.NewInt(1), 100)) != 0 {
+		t.Errorf("got %v for a big integer", b)
+	}
+
+	nonString := NewMap()
+	nonString.Insert(NewInteger(1), NewInteger(2))
+	if err := ToGo(nonString, &p); err == nil || !strings.Contains(err.Error(), "cannot convert map with integer keys") {
+		t.Errorf("got %v, want an error about the keys", err)
+	}
+	wrongField := NewMap()
+	wrongField.Insert(NewString("X"), NewString("one"))
+	if err := ToGo(wrongField, &p); err == nil || !strings.Contains(err.Error(), "field 'X': cannot convert string to Go int") {
+		t.Errorf("got %v, want an error naming the field", err)
+	}
+}
+
+func bridgeDivide(a int, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("cannot divide by zero")
+	}
+	return a / b, nil
+}
+
+func TestWrapGoFunc(t *testing.T) {
+	if name := WrapGoFunc(bridgeDivide).String(); name != "<native fn 'bridgeDivide'>" {
+		t.Errorf("got %s, want it named after the Go function", name)
+	}
+	if name := WrapGoFunc(func() {}).String(); name != "<anonymous native fn>" {
+		t.Errorf("got %s for a function literal", name)
+	}
+
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetGlobal("divide", WrapGoFunc(bridgeDivide))
+		in.SetGlobal("join", WrapGoFunc(func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		}))
+
+		_, err := in.Eval(`
+			print divide(7, 2);
+			print join("-", "a", "b", "c");
+			print join;
+			try { divide(1, 0); } catch (e) { print e.message; }
+			try { divide("1", 2); } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "3\na-b-c\n<native fn 'join'>\ncannot divide by zero\n" +
+			"argument 1: cannot convert string to Go int\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+
+		// Errors from a native should point at where it was called
+		_, err = in.Eval("\ndivide(1, 0);")
+		if err == nil || !strings.HasPrefix(err.Error(), "<input>:2:") {
+			t.Errorf("%s: got error %v, want one on line 2", engine.name, err)
+		}
+	}
+}
+
+func TestWrapGoFuncPanics(t *testing.T) {
+	for _, fn := range []interface{}{42, (func())(nil), func() (int, int) { return 0, 0 }} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WrapGoFunc(%T) did not panic", fn)
+				}
+			}()
+			WrapGoFunc(fn)
+		}()
+	}
+}
+
+func TestWrapGoFuncArity(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetGlobal("divide", WrapGoFunc(bridgeDivide))
+		in.SetGlobal("sum", WrapGoFunc(func(first int, rest ...int) int {
+			for _, n := range rest {
+				first += n
+			}
+			return first
+		}))
+
+		_, err := in.Eval(`
+			print sum(1);
+			print sum(1, 2, 3);
+			try { sum(); } catch (e) { print e.message; }
+			try { divide(1); } catch (e) { print e.message; }
+			try { sum(1, "2"); } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "1\n6\nexpected at least 1 argument(s) but got 0\n" +
+			"expected 2 argument(s) but got 1\nargument 2: cannot convert string to Go int\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OpCode byte
+
+const (
+	OpConstant OpCode = iota
+	OpNil
+	OpTrue
+	OpFalse
+	OpUninitialized
+	OpPop
+	OpPick
+	OpBury
+	OpGetLocal
+	OpSetLocal
+	OpGetUpvalue
+	OpSetUpvalue
+	OpDefineGlobal
+	OpGetGlobal
+	OpSetGlobal
+	OpGetProperty
+	OpSetProperty
+	OpGetSuper
+	OpEqual
+	OpNotEqual
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpModulo
+	OpFloorDivide
+	OpPower
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpNot
+	OpNegate
+	OpBitNot
+	OpPrint
+	OpJump
+	OpJumpIfFalse
+	OpJumpIfPassed
+	OpLoop
+	OpIter
+	OpUnpackPair
+	OpCall
+	OpCallNamed
+	OpClosure
+	OpCloseUpvalue
+	OpReturn
+	OpClass
+	OpMetaclass
+	OpMethod
+	OpClassMethod
+	OpConcat
+	OpList
+	OpListAppend
+	OpMap
+	OpMapInsert
+	OpGetIndex
+	OpSetIndex
+	OpTry
+	OpTryFinally
+	OpPopHandler
+	OpThrow
+	OpRethrow
+	OpImport
+)
+
+var opCodeStringMap = map[OpCode]string{
+	OpConstant:      "Constant",
+	OpNil:           "Nil",
+	OpTrue:          "True",
+	OpFalse:         "False",
+	OpUninitialized: "Uninitialized",
+	OpPop:           "Pop",
+	OpPick:          "Pick",
+	OpBury:          "Bury",
+	OpGetLocal:      "GetLocal",
+	OpSetLocal:      "SetLocal",
+	OpGetUpvalue:    "GetUpvalue",
+	OpSetUpvalue:    "SetUpvalue",
+	OpDefineGlobal:  "DefineGlobal",
+	OpGetGlobal:     "GetGlobal",
+	OpSetGlobal:     "SetGlobal",
+	OpGetProperty:   "GetProperty",
+	OpSetProperty:   "SetProperty",
+	OpGetSuper:      "GetSuper",
+	OpEqual:         "Equal",
+	OpNotEqual:      "NotEqual",
+	OpGreater:       "Greater",
+	OpGreaterEqual:  "GreaterEqual",
+	OpLess:          "Less",
+	OpLessEqual:     "LessEqual",
+	OpAdd:           "Add",
+	OpSubtract:      "Subtract",
+	OpMultiply:      "Multiply",
+	OpDivide:        "Divide",
+	OpModulo:        "Modulo",
+	OpFloorDivide:   "FloorDivide",
+	OpPower:         "Power",
+	OpBitAnd:        "BitAnd",
+	OpBitOr:         "BitOr",
+	OpBitXor:        "BitXor",
+	OpShiftLeft:     "ShiftLeft",
+	OpShiftRight:    "ShiftRight",
+	OpNot:           "Not",
+	OpNegate:        "Negate",
+	OpBitNot:        "BitNot",
+	OpPrint:         "Print",
+	OpJump:          "Jump",
+	OpJumpIfFalse:   "JumpIfFalse",
+	OpJumpIfPassed:  "JumpIfPassed",
+	OpLoop:          "Loop",
+	OpIter:          "Iter",
+	OpUnpackPair:    "UnpackPair",
+	OpCall:          "Call",
+	OpCallNamed:     "CallNamed",
+	OpClosure:       "Closure",
+	OpCloseUpvalue:  "CloseUpvalue",
+	OpReturn:        "Return",
+	OpClass:         "Class",
+	OpMetaclass:     "Metaclass",
+	OpMethod:        "Method",
+	OpClassMethod:   "ClassMethod",
+	OpConcat:        "Concat",
+	OpList:          "List",
+	OpListAppend:    "ListAppend",
+	OpMap:           "Map",
+	OpMapInsert:     "MapInsert",
+	OpGetIndex:      "GetIndex",
+	OpSetIndex:      "SetIndex",
+	OpTry:           "Try",
+	OpTryFinally:    "TryFinally",
+	OpPopHandler:    "PopHandler",
+	OpThrow:         "Throw",
+	OpRethrow:       "Rethrow",
+	OpImport:        "Import",
+}
+
+func (op OpCode) String() string {
+	return opCodeStringMap[op]
+}
+
+// A chunk is a sequence of bytecode instructions along with the constants
+// they reference. Each instruction is a single opcode byte followed by
+// zero or more operand bytes; multi-byte operands are big-endian.
+type Chunk struct {
+	code      []byte
+	positions []Position
+	constants []Value
+
+	// Names of the variables read by OpGetLocal/OpGetUpvalue, keyed by
+	// instruction offset. Only used to report uninitialized variables.
+	names map[int]string
+}
+
+func NewChunk() *Chunk {
+	return &Chunk{
+		code:      []byte{},
+		positions: []Position{},
+		constants: []Value{},
+		names:     map[int]string{},
+	}
+}
+
+func (c *Chunk) write(b byte, position Position) {
+	c.code = append(c.code, b)
+	c.positions = append(c.positions, position)
+}
+
+func (c *Chunk) addConstant(value Value) int {
+	// Reuse existing constants for strings and numbers, since names of
+	// globals and properties tend to be repeated a lot
+	switch value.Type() {
+	case TypeInteger, TypeNumber, TypeString:
+		for i, constant := range c.constants {
+			if constant.Type() == value.Type() && constant.Equal(value) {
+				return i
+			}
+		}
+	}
+	c.constants = append(c.constants, value)
+	return len(c.constants) - 1
+}
+
+func (c *Chunk) readShort(offset int) int {
+	return int(c.code[offset])<<8 | int(c.code[offset+1])
+}
+
+// Disassemble returns a human-readable listing of the chunk and of any
+// functions defined within it.
+func (c *Chunk) Disassemble(name string) string {
+	sb := strings.Builder{}
+	c.disassemble(&sb, name)
+	return sb.String()
+}
+
+func (c *Chunk) disassemble(sb *strings.Builder, name string) {
+	fmt.Fprintf(sb, "== %s ==\n", name)
+	protos := []*FnProto{}
+	for offset := 0; offset < len(c.code); {
+		op := OpCode(c.code[offset])
+		fmt.Fprintf(sb, "%04d %4d %-14v", offset, c.positions[offset].line, op)
+		switch op {
+		case OpConstant,
+			OpDefineGlobal,
+			OpGetGlobal,
+			OpSetGlobal,
+			OpGetProperty,
+			OpSetProperty,
+			OpGetSuper,
+			OpClass,
+			OpMethod,
+			OpClassMethod,
+			OpImport:
+
+			index := c.readShort(offset + 1)
+			fmt.Fprintf(sb, " %4d %s\n", index, c.constants[index].Repr())
+			offset += 3
+			if op == OpClass {
+				fmt.Fprintf(sb, "%04d    | super %d\n", offset, c.code[offset])
+				offset++
+			}
+		case OpPick, OpBury, OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall, OpIter:
+			fmt.Fprintf(sb, " %4d\n", c.code[offset+1])
+			offset += 2
+		case OpJump, OpJumpIfFalse, OpTry, OpTryFinally:
+			jump := c.readShort(offset + 1)
+			fmt.Fprintf(sb, " -> %04d\n", offset+3+jump)
+			offset += 3
+		case OpCallNamed:
+			index := c.readShort(offset + 2)
+			fmt.Fprintf(sb, " %4d %s\n", c.code[offset+1], c.constants[index].Repr())
+			offset += 4
+		case OpJumpIfPassed:
+			jump := c.readShort(offset + 2)
+			fmt.Fprintf(sb, " %4d -> %04d\n", c.code[offset+1], offset+4+jump)
+			offset += 4
+		case OpLoop:
+			jump := c.readShort(offset + 1)
+			fmt.Fprintf(sb, " -> %04d\n", offset+3-jump)
+			offset += 3
+		case OpClosure:
+			index := c.readShort(offset + 1)
+			proto := c.constants[index].(*FnProto)
+			protos = append(protos, proto)
+			fmt.Fprintf(sb, " %4d %s\n", index, proto.Repr())
+			offset += 3
+			for i := 0; i < proto.upvalueCount; i++ {
+				kind := "upvalue"
+				if c.code[offset] == 1 {
+					kind = "local"
+				}
+				fmt.Fprintf(sb, "%04d    | %s %d\n", offset, kind, c.code[offset+1])
+				offset += 2
+			}
+		default:
+			sb.WriteString("\n")
+			offset++
+		}
+	}
+
+	for _, proto := range protos {
+		proto.chunk.disassemble(sb, proto.String())
+	}
+}
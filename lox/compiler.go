@@ -0,0 +1,631 @@
+package lox
+
+import (
+	"fmt"
+	"math"
+)
+
+type CompileError struct {
+	position Position
+	message  string
+}
+
+func newCompileError(position Position, message string) *CompileError {
+	return &CompileError{
+		position: position,
+		message:  message,
+	}
+}
+
+func (e *CompileError) Position() Position {
+	return e.position
+}
+
+func (e *CompileError) Message() string {
+	return e.message
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%v: compile error: %s", e.position, e.message) + e.position.snippet()
+}
+
+type compilerLocal struct {
+	name       string
+	depth      int
+	isCaptured bool
+	hidden     bool
+}
+
+type compilerUpvalue struct {
+	index   int
+	isLocal bool
+}
+
+type compilerLoop struct {
+	label      *Token
+	scopeDepth int
+	breaks     []int
+	continues  []int
+}
+
+// A try statement whose body (or catch block) is being compiled. Jumping
+// out of one needs to remove its exception handler and run its finally
+// block on the way out.
+type compilerTry struct {
+	finally    *BlockStmt
+	localCount int
+	loopCount  int
+}
+
+// State for the function currently being compiled. Functions nest, so
+// these form a stack linked through enclosing.
+type fnCompiler struct {
+	enclosing  *fnCompiler
+	function   *FnProto
+	ty         FunctionType
+	locals     []compilerLocal
+	upvalues   []compilerUpvalue
+	scopeDepth int
+	loops      []*compilerLoop
+	tries      []*compilerTry
+}
+
+// Compiles a resolved AST into bytecode for the VM. Like the interpreter,
+// this relies on the resolver having already rejected invalid programs, so
+// the only errors reported here are limits of the bytecode format.
+type Compiler struct {
+	current  *fnCompiler
+	position Position
+	errors   []*CompileError
+}
+
+func NewCompiler() *Compiler {
+	return &Compiler{
+		current:  nil,
+		position: Position{source: nil, offset: 0, line: 1, column: 1, length: 0},
+		errors:   []*CompileError{},
+	}
+}
+
+func (c *Compiler) CompileStatements(stmts []Stmt) (*FnProto, []*CompileError) {
+	c.beginFunction(nil, nil, FunctionTypeNone, false)
+	for _, stmt := range stmts {
+		stmt.Compile(c)
+	}
+	c.emitReturn()
+	return c.endFunction(), c.errors
+}
+
+func (c *Compiler) CompileExpression(expr Expr) (*FnProto, []*CompileError) {
+	c.beginFunction(nil, nil, FunctionTypeNone, false)
+	expr.Compile(c)
+	c.emitOp(OpReturn)
+	return c.endFunction(), c.errors
+}
+
+func (c *Compiler) addError(message string) {
+	c.errors = append(c.errors, newCompileError(c.position, message))
+}
+
+func (c *Compiler) setPosition(token Token) {
+	c.position = token.Position
+}
+
+func (c *Compiler) chunk() *Chunk {
+	return c.current.function.chunk
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().write(b, c.position)
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitShort(n int) {
+	c.emitByte(byte(n >> 8))
+	c.emitByte(byte(n))
+}
+
+func (c *Compiler) emitOpShort(op OpCode, n int) {
+	c.emitOp(op)
+	c.emitShort(n)
+}
+
+func (c *Compiler) makeConstant(value Value) int {
+	index := c.chunk().addConstant(value)
+	if index > math.MaxUint16 {
+		c.addError("too many constants in one function")
+		return 0
+	}
+	return index
+}
+
+func (c *Compiler) emitConstant(value Value) {
+	c.emitOpShort(OpConstant, c.makeConstant(value))
+}
+
+func (c *Compiler) identifierConstant(name string) int {
+	return c.makeConstant(NewString(name))
+}
+
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOp(op)
+	c.emitShort(0xffff)
+	return len(c.chunk().code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.chunk().code) - offset - 2
+	if jump > math.MaxUint16 {
+		c.addError("too much code to jump over")
+	}
+	c.chunk().code[offset] = byte(jump >> 8)
+	c.chunk().code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(start int) {
+	c.emitOp(OpLoop)
+	offset := len(c.chunk().code) - start + 2
+	if offset > math.MaxUint16 {
+		c.addError("loop body too large")
+	}
+	c.emitShort(offset)
+}
+
+func (c *Compiler) emitReturnValue() {
+	if c.current.ty == FunctionTypeInitializer {
+		c.emitOp(OpGetLocal)
+		c.emitByte(0)
+	} else {
+		c.emitOp(OpNil)
+	}
+}
+
+func (c *Compiler) emitReturn() {
+	c.emitReturnValue()
+	c.emitOp(OpReturn)
+}
+
+// Returns the value on top of the stack, running any finally blocks
+// that the return jumps out of first.
+func (c *Compiler) emitUnwindingReturn() {
+	fc := c.current
+	if len(fc.tries) > 0 {
+		c.withTemporaries(1, func() {
+			c.unwindTries(len(fc.tries))
+		})
+	}
+	c.emitOp(OpReturn)
+}
+
+func (c *Compiler) beginFunction(name *string, class *string, ty FunctionType, isProperty bool) {
+	fc := &fnCompiler{
+		enclosing:  c.current,
+		function:   newFnProto(name, class, ty, isProperty),
+		ty:         ty,
+		locals:     []compilerLocal{},
+		upvalues:   []compilerUpvalue{},
+		scopeDepth: 0,
+		loops:      []*compilerLoop{},
+		tries:      []*compilerTry{},
+	}
+
+	// Slot 0 holds the receiver for methods and the callee otherwise
+	slotName := ""
+	if ty == FunctionTypeMethod || ty == FunctionTypeInitializer {
+		slotName = "this"
+	}
+	fc.locals = append(fc.locals, compilerLocal{name: slotName, depth: 0})
+
+	c.current = fc
+}
+
+func (c *Compiler) endFunction() *FnProto {
+	fc := c.current
+	fc.function.upvalueCount = len(fc.upvalues)
+	c.current = fc.enclosing
+	return fc.function
+}
+
+func (c *Compiler) function(e FnExpr, name *string, class *string, ty FunctionType, isProperty bool) {
+	c.beginFunction(name, class, ty, isProperty)
+	c.beginScope()
+
+	// Hide the parameters while compiling the defaults, which are
+	// evaluated as if they were outside the function
+	for _, param := range e.parameters {
+		c.current.function.arity++
+		c.declareVariable(param)
+		c.defineVariable(param)
+		c.current.locals[len(c.current.locals)-1].hidden = true
+	}
+	c.current.function.minArity, _ = e.arity()
+	c.current.function.variadic = e.variadic
+	c.current.function.parameters = e.parameterNames()
+
+	for i, value := range e.defaults {
+		if value == nil {
+			continue
+		}
+		c.setPosition(e.parameters[i])
+		c.emitOp(OpJumpIfPassed)
+		c.emitByte(byte(i + 1))
+		c.emitShort(0xffff)
+		jump := len(c.chunk().code) - 2
+		value.Compile(c)
+		c.emitOp(OpSetLocal)
+		c.emitByte(byte(i + 1))
+		c.emitOp(OpPop)
+		c.patchJump(jump)
+	}
+	for i := range e.parameters {
+		c.current.locals[i+1].hidden = false
+	}
+
+	for _, stmt := range e.body {
+		stmt.Compile(c)
+	}
+	c.emitReturn()
+
+	upvalues := c.current.upvalues
+	proto := c.endFunction()
+
+	c.emitOpShort(OpClosure, c.makeConstant(proto))
+	for _, upvalue := range upvalues {
+		if upvalue.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(byte(upvalue.index))
+	}
+}
+
+func (c *Compiler) beginScope() {
+	c.current.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	fc := c.current
+	fc.scopeDepth--
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		c.popLocal(fc.locals[len(fc.locals)-1])
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+func (c *Compiler) popLocal(local compilerLocal) {
+	if local.isCaptured {
+		c.emitOp(OpCloseUpvalue)
+	} else {
+		c.emitOp(OpPop)
+	}
+}
+
+func (c *Compiler) beginLoop(label *Token) {
+	c.current.loops = append(c.current.loops, &compilerLoop{
+		label:      label,
+		scopeDepth: c.current.scopeDepth,
+		breaks:     []int{},
+		continues:  []int{},
+	})
+}
+
+func (c *Compiler) endLoop() {
+	fc := c.current
+	loop := fc.loops[len(fc.loops)-1]
+	for _, offset := range loop.breaks {
+		c.patchJump(offset)
+	}
+	fc.loops = fc.loops[:len(fc.loops)-1]
+}
+
+// Patches the continues in the innermost loop to jump to the current
+// position, which is where the body ends.
+func (c *Compiler) patchContinues() {
+	fc := c.current
+	loop := fc.loops[len(fc.loops)-1]
+	for _, offset := range loop.continues {
+		c.patchJump(offset)
+	}
+	loop.continues = loop.continues[:0]
+}
+
+func (c *Compiler) emitBreak(label *Token) {
+	loop := c.unwindLoop(label)
+	loop.breaks = append(loop.breaks, c.emitJump(OpJump))
+}
+
+func (c *Compiler) emitContinue(label *Token) {
+	loop := c.unwindLoop(label)
+	loop.continues = append(loop.continues, c.emitJump(OpJump))
+}
+
+// Emits code to run the finally blocks and discard the locals inside the
+// body of the loop with the given label (or the innermost loop if nil),
+// returning the loop.
+func (c *Compiler) unwindLoop(label *Token) *compilerLoop {
+	fc := c.current
+	loopIndex := len(fc.loops) - 1
+	if label != nil {
+		for !targetsLoop(label.lexeme, fc.loops[loopIndex].label) {
+			loopIndex--
+		}
+	}
+	loop := fc.loops[loopIndex]
+
+	tryCount := 0
+	for _, try := range fc.tries {
+		if try.loopCount > loopIndex {
+			tryCount++
+		}
+	}
+	c.unwindTries(tryCount)
+
+	c.discardLocals(loop.scopeDepth)
+	return loop
+}
+
+// Pops the locals deeper than the given scope depth from the stack without
+// forgetting about them, for jumps out of the scope. Upvalues are always
+// closed, since a local may be captured by code compiled after the jump.
+func (c *Compiler) discardLocals(depth int) {
+	fc := c.current
+	for i := len(fc.locals) - 1; i >= 0 && fc.locals[i].depth > depth; i-- {
+		c.emitOp(OpCloseUpvalue)
+	}
+}
+
+// Emits an exception handler for a try statement, returning the offset
+// of the jump to the handler for patching. The handler is entered with the
+// caught value on the stack for OpTry, or with the exception itself for
+// OpTryFinally, which rethrows it once the finally block has run.
+func (c *Compiler) beginTry(op OpCode, finally *BlockStmt, localCount int) int {
+	fc := c.current
+	fc.tries = append(fc.tries, &compilerTry{
+		finally:    finally,
+		localCount: localCount,
+		loopCount:  len(fc.loops),
+	})
+	return c.emitJump(op)
+}
+
+func (c *Compiler) endTry() {
+	fc := c.current
+	fc.tries = fc.tries[:len(fc.tries)-1]
+	c.emitOp(OpPopHandler)
+}
+
+// Removes the handlers of the innermost tries, running each of their
+// finally blocks, before jumping out of them.
+func (c *Compiler) unwindTries(count int) {
+	fc := c.current
+	tries := fc.tries
+	loops := fc.loops
+	for i := len(tries) - 1; i >= len(tries)-count; i-- {
+		try := tries[i]
+		c.emitOp(OpPopHandler)
+		if try.finally != nil {
+			// The finally block is compiled as if it were where the try
+			// statement is: it can't see locals declared in the try, and
+			// breaking from it refers to loops enclosing the try
+			fc.tries = tries[:i]
+			fc.loops = loops[:try.loopCount]
+			c.compileHidingLocals(try.localCount, *try.finally)
+		}
+	}
+	fc.tries = tries
+	fc.loops = loops
+}
+
+func (c *Compiler) compileHidingLocals(localCount int, stmt Stmt) {
+	fc := c.current
+	hidden := make([]bool, len(fc.locals))
+	for i := range fc.locals {
+		hidden[i] = fc.locals[i].hidden
+		if i >= localCount {
+			fc.locals[i].hidden = true
+		}
+	}
+
+	stmt.Compile(c)
+
+	for i := range hidden {
+		fc.locals[i].hidden = hidden[i]
+	}
+}
+
+// Runs a finally block for an exception that was not caught, then throws
+// it again as it was. Expects the exception, as pushed for OpTryFinally, to
+// be on top of the stack, with the
+// given number of values (including the exception) above the locals.
+func (c *Compiler) compileFinallyAndRethrow(finally BlockStmt, temporaries int) {
+	c.withTemporaries(temporaries, func() {
+		finally.Compile(c)
+	})
+	c.emitOp(OpRethrow)
+}
+
+// Compiles code that runs with values on the stack that are not visible
+// as variables, but still need to be accounted for when allocating slots
+// for locals or discarding them on a jump out of the current scope.
+func (c *Compiler) withTemporaries(count int, compile func()) {
+	fc := c.current
+	fc.scopeDepth++
+	for i := 0; i < count; i++ {
+		fc.locals = append(fc.locals, compilerLocal{
+			name:   "",
+			depth:  fc.scopeDepth,
+			hidden: true,
+		})
+	}
+
+	compile()
+
+	fc.locals = fc.locals[:len(fc.locals)-count]
+	fc.scopeDepth--
+}
+
+func (c *Compiler) declareVariable(name Token) {
+	fc := c.current
+	if fc.scopeDepth == 0 {
+		return
+	}
+
+	if len(fc.locals) > math.MaxUint8 {
+		c.addError("too many local variables in function")
+		return
+	}
+
+	fc.locals = append(fc.locals, compilerLocal{
+		name:  name.lexeme,
+		depth: -1,
+	})
+}
+
+func (c *Compiler) markInitialized() {
+	fc := c.current
+	if fc.scopeDepth == 0 {
+		return
+	}
+	fc.locals[len(fc.locals)-1].depth = fc.scopeDepth
+}
+
+func (c *Compiler) defineVariable(name Token) {
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitOpShort(OpDefineGlobal, c.identifierConstant(name.lexeme))
+}
+
+// Declares a local holding the value currently on top of the stack,
+// for variables that don't appear in the source such as super.
+func (c *Compiler) addSyntheticLocal(name string) {
+	fc := c.current
+	fc.locals = append(fc.locals, compilerLocal{
+		name:  name,
+		depth: fc.scopeDepth,
+	})
+}
+
+func resolveLocal(fc *fnCompiler, name string) int {
+	for i := len(fc.locals) - 1; i >= 0; i-- {
+		if fc.locals[i].name == name && !fc.locals[i].hidden {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) resolveUpvalue(fc *fnCompiler, name string) int {
+	if fc.enclosing == nil {
+		return -1
+	}
+
+	local := resolveLocal(fc.enclosing, name)
+	if local != -1 {
+		fc.enclosing.locals[local].isCaptured = true
+		return c.addUpvalue(fc, local, true)
+	}
+
+	upvalue := c.resolveUpvalue(fc.enclosing, name)
+	if upvalue != -1 {
+		return c.addUpvalue(fc, upvalue, false)
+	}
+
+	return -1
+}
+
+func (c *Compiler) addUpvalue(fc *fnCompiler, index int, isLocal bool) int {
+	for i, upvalue := range fc.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(fc.upvalues) > math.MaxUint8 {
+		c.addError("too many closure variables in function")
+		return 0
+	}
+
+	fc.upvalues = append(fc.upvalues, compilerUpvalue{
+		index:   index,
+		isLocal: isLocal,
+	})
+	return len(fc.upvalues) - 1
+}
+
+func (c *Compiler) emitGetVariable(name Token) {
+	c.setPosition(name)
+	if slot := resolveLocal(c.current, name.lexeme); slot != -1 {
+		c.chunk().names[len(c.chunk().code)] = name.lexeme
+		c.emitOp(OpGetLocal)
+		c.emitByte(byte(slot))
+	} else if index := c.resolveUpvalue(c.current, name.lexeme); index != -1 {
+		c.chunk().names[len(c.chunk().code)] = name.lexeme
+		c.emitOp(OpGetUpvalue)
+		c.emitByte(byte(index))
+	} else {
+		c.emitOpShort(OpGetGlobal, c.identifierConstant(name.lexeme))
+	}
+}
+
+func (c *Compiler) emitBinaryOp(operator Token) {
+	c.setPosition(operator)
+	switch operator.ty {
+	case TokenTypeMinus:
+		c.emitOp(OpSubtract)
+	case TokenTypeSlash:
+		c.emitOp(OpDivide)
+	case TokenTypeStar:
+		c.emitOp(OpMultiply)
+	case TokenTypePercent:
+		c.emitOp(OpModulo)
+	case TokenTypeTildeSlash:
+		c.emitOp(OpFloorDivide)
+	case TokenTypeStarStar:
+		c.emitOp(OpPower)
+	case TokenTypeAmpersand:
+		c.emitOp(OpBitAnd)
+	case TokenTypePipe:
+		c.emitOp(OpBitOr)
+	case TokenTypeCaret:
+		c.emitOp(OpBitXor)
+	case TokenTypeLessLess:
+		c.emitOp(OpShiftLeft)
+	case TokenTypeGreaterGreater:
+		c.emitOp(OpShiftRight)
+	case TokenTypeGreater:
+		c.emitOp(OpGreater)
+	case TokenTypeGreaterEqual:
+		c.emitOp(OpGreaterEqual)
+	case TokenTypeLess:
+		c.emitOp(OpLess)
+	case TokenTypeLessEqual:
+		c.emitOp(OpLessEqual)
+	case TokenTypePlus:
+		c.emitOp(OpAdd)
+	case TokenTypeBangEqual:
+		c.emitOp(OpNotEqual)
+	case TokenTypeEqualEqual:
+		c.emitOp(OpEqual)
+	default:
+		panic(fmt.Sprintf("unknown binary operator: %v", operator.ty))
+	}
+}
+
+func (c *Compiler) emitSetVariable(name Token) {
+	c.setPosition(name)
+	if slot := resolveLocal(c.current, name.lexeme); slot != -1 {
+		c.emitOp(OpSetLocal)
+		c.emitByte(byte(slot))
+	} else if index := c.resolveUpvalue(c.current, name.lexeme); index != -1 {
+		c.emitOp(OpSetUpvalue)
+		c.emitByte(byte(index))
+	} else {
+		c.emitOpShort(OpSetGlobal, c.identifierConstant(name.lexeme))
+	}
+}
@@ -2,56 +2,143 @@ package lox
 
 import (
 	"fmt"
+	"path/filepath"
 )
 
+// Variables are stored in slices indexed by the slot the resolver assigned
+// them within their scope, so a lookup is just a walk up the enclosing
+// chain followed by an index. The outermost environment is special in that
+// it holds the globals by name, since globals may be defined after the
+// code using them has been resolved (e.g. natives, or across REPL lines).
+// Each module gets its own outermost environment, which also knows which
+// directory the module's imports are relative to, where to print to and
+// read from, and how deeply calls are nested.
 type Environment struct {
 	enclosing *Environment
-	values    map[string]*Value
+	slots     []Value
+	globals   map[string]Value
+	modules   *moduleLoader
+	dir       string
+	streams   *streams
+	calls     *callDepth
 }
 
-func NewEnvironment(outer *Environment) *Environment {
+// How deeply calls are nested in an interpreter, and how deeply they may be
+type callDepth struct {
+	depth int
+	max   int
+}
+
+func NewGlobalEnvironment() *Environment {
+	return &Environment{
+		enclosing: nil,
+		slots:     nil,
+		globals:   map[string]Value{},
+		modules:   newModuleLoader(),
+		dir:       "",
+		streams:   newStreams(),
+		calls:     &callDepth{depth: 0, max: defaultMaxCallDepth},
+	}
+}
+
+func newModuleEnvironment(parent *Environment, globals map[string]Value, dir string) *Environment {
+	return &Environment{
+		enclosing: nil,
+		slots:     nil,
+		globals:   globals,
+		modules:   parent.modules,
+		dir:       dir,
+		streams:   parent.streams,
+		calls:     parent.calls,
+	}
+}
+
+func NewEnvironment(outer *Environment, size int) *Environment {
 	return &Environment{
 		enclosing: outer,
-		values:    map[string]*Value{},
+		slots:     make([]Value, size),
+		globals:   nil,
+		modules:   nil,
+		dir:       "",
+		streams:   nil,
+		calls:     nil,
 	}
 }
 
+// Sets the path of the script being run, which imports are relative to.
+func (e *Environment) SetScriptPath(path string) {
+	root := e.ancestor(-1)
+	root.dir = filepath.Dir(path)
+	root.modules.setMain(path)
+}
+
 func (e *Environment) ancestor(distance int) *Environment {
 	curr := e
-	for distance != 0 {
-		if curr.enclosing == nil {
-			return curr
+	if distance < 0 {
+		for curr.enclosing != nil {
+			curr = curr.enclosing
 		}
+		return curr
+	}
+	for distance != 0 {
 		curr = curr.enclosing
 		distance--
 	}
 	return curr
 }
 
-func (e *Environment) Declare(name Token) {
-	e.values[name.lexeme] = nil
+// Marks a variable as declared but not yet initialized. Slot -1 refers
+// to a global.
+func (e *Environment) Declare(slot int, name Token) {
+	if slot < 0 {
+		e.globals[name.lexeme] = nil
+	} else {
+		e.slots[slot] = nil
+	}
 }
 
-func (e *Environment) Define(name Token, value Value) {
-	e.values[name.lexeme] = &value
+func (e *Environment) Define(slot int, name Token, value Value) {
+	if slot < 0 {
+		e.globals[name.lexeme] = value
+	} else {
+		e.slots[slot] = value
+	}
 }
 
 func (e *Environment) DefineNative(name string, value Value) {
-	e.values[name] = &value
+	root := e.ancestor(-1)
+	root.globals[name] = value
+	root.modules.natives[name] = value
 }
 
-func (e *Environment) Assign(distance int, name Token, value Value) RuntimeException {
-	e.ancestor(distance).values[name.lexeme] = &value
+func (e *Environment) DefineAt(slot int, value Value) {
+	e.slots[slot] = value
+}
+
+func (e *Environment) Assign(distance int, slot int, name Token, value Value) RuntimeException {
+	env := e.ancestor(distance)
+	if distance < 0 {
+		env.globals[name.lexeme] = value
+	} else {
+		env.slots[slot] = value
+	}
 	return nil
 }
 
-func (e *Environment) Get(distance int, name Token) (Value, RuntimeException) {
-	value, ok := e.ancestor(distance).values[name.lexeme]
-	if !ok {
-		return nil, NewRuntimeError(
-			name,
-			fmt.Sprintf("using undeclared variable '%s'", name.lexeme),
-		)
+func (e *Environment) Get(distance int, slot int, name Token) (Value, RuntimeException) {
+	env := e.ancestor(distance)
+	var value Value
+	if distance < 0 {
+		var ok bool
+		value, ok = env.globals[name.lexeme]
+		if !ok {
+			return nil, NewRuntimeError(
+				name,
+				fmt.Sprintf("using undeclared variable '%s'", name.lexeme),
+			)
+		}
+	} else {
+		value = env.slots[slot]
 	}
 	if value == nil {
 		return nil, NewRuntimeError(
@@ -59,16 +146,27 @@ func (e *Environment) Get(distance int, name Token) (Value, RuntimeException) {
 			fmt.Sprintf("using uninitialized variable '%s'", name.lexeme),
 		)
 	}
-	return *value, nil
+	return value, nil
 }
 
-func (e *Environment) GetNative(distance int, name string) Value {
-	value, ok := e.ancestor(distance).values[name]
-	if !ok {
-		panic(fmt.Sprintf("using undeclared variable '%s'", name))
-	}
+func (e *Environment) GetAt(distance int, slot int) Value {
+	value := e.ancestor(distance).slots[slot]
 	if value == nil {
-		panic(fmt.Sprintf("using uninitialized variable '%s'", name))
+		panic(fmt.Sprintf("using uninitialized slot %d", slot))
 	}
-	return *value
+	return value
+}
+
+func (e *Environment) importModule(token Token, path string) (*Module, RuntimeException) {
+	root := e.ancestor(-1)
+	return root.modules.load(token, root.dir, path, func(stmts []Stmt, globals map[string]Value, dir string) RuntimeException {
+		env := newModuleEnvironment(root, globals, dir)
+		for _, stmt := range stmts {
+			err := stmt.Execute(env)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
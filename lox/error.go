@@ -2,36 +2,45 @@ package lox
 
 import (
 	"fmt"
+	"strings"
 )
 
 type SyntaxError struct {
-	line    int
-	token   *Token
-	message string
+	position Position
+	token    *Token
+	message  string
 }
 
-func NewSyntaxError(line int, token *Token, message string) *SyntaxError {
+func NewSyntaxError(position Position, token *Token, message string) *SyntaxError {
 	return &SyntaxError{
-		line:    line,
-		token:   token,
-		message: message,
+		position: position,
+		token:    token,
+		message:  message,
 	}
 }
 
+func (e *SyntaxError) Position() Position {
+	return e.position
+}
+
+func (e *SyntaxError) Message() string {
+	return e.message
+}
+
 func (e *SyntaxError) Error() string {
 	if e.token != nil {
 		return fmt.Sprintf(
-			"syntax error on line %d at %s: %s",
-			e.line,
+			"%v: syntax error at %s: %s",
+			e.position,
 			e.token.lexeme,
 			e.message,
-		)
+		) + e.position.snippet()
 	} else {
 		return fmt.Sprintf(
-			"syntax error on line %d: %s",
-			e.line,
+			"%v: syntax error: %s",
+			e.position,
 			e.message,
-		)
+		) + e.position.snippet()
 	}
 }
 
@@ -40,9 +49,83 @@ type RuntimeException interface{}
 type RuntimeError struct {
 	token   Token
 	message string
+	trace   []StackFrame
+}
+
+// A call that was in progress when an error was raised, from the callee's
+// point of view
+type StackFrame struct {
+	function string
+	site     Position
+}
+
+func (f StackFrame) Function() string {
+	return f.function
+}
+
+func (f StackFrame) Site() Position {
+	return f.site
+}
+
+// Formats a trace innermost call first, collapsing runs of identical
+// frames (e.g. from unbounded recursion)
+func formatTrace(trace []StackFrame) string {
+	var sb strings.Builder
+	for i := 0; i < len(trace); {
+		frame := trace[i]
+		fmt.Fprintf(&sb, "\n    in %s called at %v", frame.function, frame.site)
+
+		repeats := 0
+		for i++; i < len(trace) && trace[i] == frame; i++ {
+			repeats++
+		}
+		if repeats > 0 {
+			fmt.Fprintf(&sb, "\n    (repeated %d more times)", repeats)
+		}
+	}
+	return sb.String()
+}
+
+// Records that an error propagated out of a call to the given function
+// made at the given position. Other exceptions are returned as-is.
+func addStackFrame(err RuntimeException, function string, site Position) RuntimeException {
+	frame := StackFrame{function: function, site: site}
+	switch err := err.(type) {
+	case *RuntimeError:
+		err.trace = append(err.trace, frame)
+	case *ThrowException:
+		err.trace = append(err.trace, frame)
+	}
+	return err
+}
+
+// Points an error that was raised without a position, such as one returned
+// by a wrapped Go function, at the call that raised it
+func locateError(err RuntimeException, site Token) RuntimeException {
+	e, ok := err.(*RuntimeError)
+	if ok && e.token.Position == (Position{}) {
+		e.token = site
+	}
+	return err
+}
+
+// Describes a function for a stack trace, including the class it was
+// defined in if it is a method
+func describeFunction(fn string, class *string) string {
+	if class != nil {
+		return fmt.Sprintf("%s of class '%s'", fn, *class)
+	}
+	return fn
+}
+
+// Raised by break and continue. An empty label targets the innermost loop.
+type BreakException struct {
+	label string
 }
 
-type BreakException struct{}
+type ContinueException struct {
+	label string
+}
 
 type ReturnException struct {
 	value Value
@@ -52,13 +135,98 @@ func NewRuntimeError(token Token, message string) *RuntimeError {
 	return &RuntimeError{
 		token:   token,
 		message: message,
+		trace:   nil,
 	}
 }
 
+func (e *RuntimeError) Position() Position {
+	return e.token.Position
+}
+
+func (e *RuntimeError) Message() string {
+	return e.message
+}
+
+// Calls that were in progress when the error was raised, innermost first
+func (e *RuntimeError) Trace() []StackFrame {
+	return e.trace
+}
+
 func (e *RuntimeError) Error() string {
 	return fmt.Sprintf(
-		"runtime error on line %d: %s",
-		e.token.line,
+		"%v: runtime error: %s",
+		e.token.Position,
 		e.message,
-	)
+	) + e.token.snippet() + formatTrace(e.trace)
+}
+
+// A value thrown by a throw statement. Runtime errors are not thrown this
+// way, but are converted into error objects when they are caught.
+type ThrowException struct {
+	token Token
+	value Value
+	trace []StackFrame
+}
+
+func NewThrowException(token Token, value Value) *ThrowException {
+	return &ThrowException{
+		token: token,
+		value: value,
+		trace: nil,
+	}
+}
+
+func (e *ThrowException) Position() Position {
+	return e.token.Position
+}
+
+func (e *ThrowException) Value() Value {
+	return e.value
+}
+
+func (e *ThrowException) Trace() []StackFrame {
+	return e.trace
+}
+
+func (e *ThrowException) Error() string {
+	// Rethrown runtime errors should still look like runtime errors
+	inst, ok := e.value.(*Instance)
+	if ok && inst.Class() == errorClass {
+		message, ok := inst.fields["message"].(String)
+		if ok {
+			return fmt.Sprintf(
+				"%v: runtime error: %s",
+				e.token.Position,
+				message.value,
+			) + e.token.snippet() + formatTrace(e.trace)
+		}
+	}
+
+	return fmt.Sprintf(
+		"%v: uncaught exception: %s",
+		e.token.Position,
+		e.value.String(),
+	) + e.token.snippet() + formatTrace(e.trace)
+}
+
+// class of the objects that runtime errors are converted to when caught
+var errorClass = func() *Class {
+	metaclass := NewClass(nil, "Error metaclass", nil, map[string]Method{})
+	return NewClass(&metaclass, "Error", nil, map[string]Method{})
+}()
+
+// Converts an exception into the value bound by a catch block. Returns
+// false if the exception is not catchable, i.e. it is break or return.
+func catchableValue(err RuntimeException) (Value, bool) {
+	switch err := err.(type) {
+	case *RuntimeError:
+		inst := NewInstance(errorClass)
+		inst.fields["message"] = NewString(err.message)
+		inst.fields["line"] = NewInteger(int64(err.token.line))
+		return inst, true
+	case *ThrowException:
+		return err.value, true
+	default:
+		return nil, false
+	}
 }
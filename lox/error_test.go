@@ -0,0 +1,105 @@
+package lox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStackTrace(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "functions and methods",
+			source: `fun inner() {
+  nil.x;
+}
+fun outer() { inner(); }
+class A { m() { outer(); } }
+A().m();`,
+			err: "<input>:2:7: runtime error: nil values have no properties\n" +
+				"      nil.x;\n" +
+				"          ^\n" +
+				"    in <fn 'inner'> called at <input>:4:21\n" +
+				"    in <fn 'outer'> called at <input>:5:23\n" +
+				"    in <fn 'm'> of class 'A' called at <input>:6:7",
+		},
+		{
+			name: "recursion",
+			source: `fun f(n) {
+  if (n == 0) throw "bottom";
+  f(n - 1);
+}
+f(3);`,
+			err: "<input>:2:15: uncaught exception: bottom\n" +
+				"      if (n == 0) throw \"bottom\";\n" +
+				"                  ^~~~~\n" +
+				"    in <fn 'f'> called at <input>:3:10\n" +
+				"    (repeated 2 more times)\n" +
+				"    in <fn 'f'> called at <input>:5:4",
+		},
+		{
+			name:   "caught errors",
+			source: "fun f() { nil.x; }\ntry { f(); } catch (e) { print e.message; }\nf();",
+			output: "nil values have no properties\n",
+			err: "<input>:1:15: runtime error: nil values have no properties\n" +
+				"    fun f() { nil.x; }\n" +
+				"                  ^\n" +
+				"    in <fn 'f'> called at <input>:3:3",
+		},
+	})
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	for _, engine := range engines {
+		in := engine.new()
+		_, err := in.Eval("fun f() { g(); }\nfun g() { nil.x; }\nf();")
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: got %v, want a *RuntimeError", engine.name, err)
+		}
+		trace := runtimeErr.Trace()
+		if len(trace) != 2 {
+			t.Fatalf("%s: got %d frames, want 2", engine.name, len(trace))
+		}
+		if trace[0].Function() != "<fn 'g'>" || trace[0].Site().line != 1 {
+			t.Errorf("%s: innermost frame is %s at %v", engine.name, trace[0].Function(), trace[0].Site())
+		}
+		if trace[1].Function() != "<fn 'f'>" || trace[1].Site().line != 3 {
+			t.Errorf("%s: outermost frame is %s at %v", engine.name, trace[1].Function(), trace[1].Site())
+		}
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name:   "runtime error",
+			source: "var x = nil;\nprint x.y;",
+			err:    "<input>:2:9: runtime error: nil values have no properties\n    print x.y;\n            ^",
+		},
+		{
+			name:   "underlines the whole token",
+			source: "var value = 1;\nprint value + unknown;",
+			err:    "<input>:2:15: runtime error: using undeclared variable 'unknown'\n    print value + unknown;\n                  ^~~~~~~",
+		},
+		{
+			name:   "keeps tabs",
+			source: "if (true) {\n\t\tprint nil.x;\n}",
+			err:    "\n    \t\tprint nil.x;\n    \t\t          ^",
+		},
+		{
+			name:   "counts characters rather than bytes",
+			source: `print "héllo" - 1;`,
+			err:    "\n    print \"héllo\" - 1;\n                  ^",
+		},
+		{
+			name:   "windows line endings",
+			source: "var x = 1;\r\nprint x.y;\r\n",
+			err:    "<input>:2:9: runtime error: integer values have no properties\n    print x.y;\n            ^",
+		},
+		{
+			name:   "syntax error",
+			source: "print 1 +;",
+			err:    "<input>:1:10: syntax error at ;: expected expression\n    print 1 +;\n             ^",
+		},
+	})
+}
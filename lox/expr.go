@@ -2,11 +2,14 @@ package lox
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
 )
 
 type Expr interface {
 	Evaluate(env *Environment) (Value, RuntimeException)
 	Resolve(r *Resolver)
+	Compile(c *Compiler)
 }
 
 type BinaryExpr struct {
@@ -35,69 +38,12 @@ func (e BinaryExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 		return nil, err
 	}
 
-	switch e.operator.ty {
-	case TokenTypeMinus,
-		TokenTypeSlash,
-		TokenTypeStar,
-		TokenTypeGreater,
-		TokenTypeGreaterEqual,
-		TokenTypeLess,
-		TokenTypeLessEqual:
-
-		if left.Type() != TypeNumber || right.Type() != TypeNumber {
-			return nil, NewRuntimeError(
-				e.operator,
-				fmt.Sprintf(
-					"%s operands must be numbers",
-					e.operator.lexeme,
-				),
-			)
-		}
-
-		l := left.(Number).Float()
-		r := right.(Number).Float()
-
-		switch e.operator.ty {
-		case TokenTypeMinus:
-			return NewNumber(l - r), nil
-		case TokenTypeSlash:
-			if r == 0 {
-				return nil, NewRuntimeError(
-					e.operator,
-					fmt.Sprintf("division by zero"),
-				)
-			}
-			return NewNumber(l / r), nil
-		case TokenTypeStar:
-			return NewNumber(l * r), nil
-		case TokenTypeGreater:
-			return NewBool(l > r), nil
-		case TokenTypeGreaterEqual:
-			return NewBool(l >= r), nil
-		case TokenTypeLess:
-			return NewBool(l < r), nil
-		case TokenTypeLessEqual:
-			return NewBool(l <= r), nil
-		default:
-			panic("unreachable")
-		}
-	case TokenTypePlus:
-		if left.Type() == TypeNumber && right.Type() == TypeNumber {
-			ln := left.(Number).Float()
-			rn := right.(Number).Float()
-			return NewNumber(ln + rn), nil
-		}
-
-		if left.Type() == TypeString || right.Type() == TypeString {
-			ls := left.String()
-			rs := right.String()
-			return NewString(ls + rs), nil
-		}
+	return binaryOp(e.operator, left, right)
+}
 
-		return nil, NewRuntimeError(
-			e.operator,
-			"+ operands must be numbers or strings",
-		)
+// Applies a binary operator to two values that have already been evaluated
+func binaryOp(operator Token, left Value, right Value) (Value, RuntimeException) {
+	switch operator.ty {
 	case TokenTypeBangEqual:
 		return NewBool(!left.Equal(right)), nil
 	case TokenTypeEqualEqual:
@@ -105,7 +51,11 @@ func (e BinaryExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 	case TokenTypeComma:
 		return right, nil
 	default:
-		panic(fmt.Sprintf("unknown binary operator: %v", e.operator.ty))
+		result, message := arithmetic(operator.ty, operator.lexeme, left, right)
+		if message != "" {
+			return nil, NewRuntimeError(operator, message)
+		}
+		return result, nil
 	}
 }
 
@@ -114,6 +64,20 @@ func (e BinaryExpr) Resolve(r *Resolver) {
 	e.right.Resolve(r)
 }
 
+func (e BinaryExpr) Compile(c *Compiler) {
+	if e.operator.ty == TokenTypeComma {
+		e.left.Compile(c)
+		c.emitOp(OpPop)
+		e.right.Compile(c)
+		return
+	}
+
+	e.left.Compile(c)
+	e.right.Compile(c)
+
+	c.emitBinaryOp(e.operator)
+}
+
 type GroupingExpr struct {
 	expression Expr
 }
@@ -130,6 +94,10 @@ func (e GroupingExpr) Resolve(r *Resolver) {
 	e.expression.Resolve(r)
 }
 
+func (e GroupingExpr) Compile(c *Compiler) {
+	e.expression.Compile(c)
+}
+
 type LiteralExpr struct {
 	value interface{}
 }
@@ -144,6 +112,10 @@ func (e LiteralExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 		return NewNil(), nil
 	case bool:
 		return NewBool(v), nil
+	case int64:
+		return NewInteger(v), nil
+	case *big.Int:
+		return NewBigInteger(v), nil
 	case float64:
 		return NewNumber(v), nil
 	case string:
@@ -157,6 +129,29 @@ func (e LiteralExpr) Resolve(r *Resolver) {
 	// No-op
 }
 
+func (e LiteralExpr) Compile(c *Compiler) {
+	switch v := e.value.(type) {
+	case nil:
+		c.emitOp(OpNil)
+	case bool:
+		if v {
+			c.emitOp(OpTrue)
+		} else {
+			c.emitOp(OpFalse)
+		}
+	case int64:
+		c.emitConstant(NewInteger(v))
+	case *big.Int:
+		c.emitConstant(NewBigInteger(v))
+	case float64:
+		c.emitConstant(NewNumber(v))
+	case string:
+		c.emitConstant(NewString(v))
+	default:
+		panic(fmt.Sprintf("unknown literal type: %T", v))
+	}
+}
+
 type UnaryExpr struct {
 	operator Token
 	right    Expr
@@ -179,17 +174,12 @@ func (e UnaryExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 	switch e.operator.ty {
 	case TokenTypeBang:
 		return NewBool(!r.Bool()), nil
-	case TokenTypeMinus:
-		if r.Type() != TypeNumber {
-			return nil, NewRuntimeError(
-				e.operator,
-				"unary - operand must be a number",
-			)
-		}
-		rn := r.(Number).Float()
-		return NewNumber(-rn), nil
 	default:
-		panic(fmt.Sprintf("unknown unary operator: %v", e.operator.ty))
+		result, message := unaryArithmetic(e.operator.ty, e.operator.lexeme, r)
+		if message != "" {
+			return nil, NewRuntimeError(e.operator, message)
+		}
+		return result, nil
 	}
 }
 
@@ -197,6 +187,22 @@ func (e UnaryExpr) Resolve(r *Resolver) {
 	e.right.Resolve(r)
 }
 
+func (e UnaryExpr) Compile(c *Compiler) {
+	e.right.Compile(c)
+
+	c.setPosition(e.operator)
+	switch e.operator.ty {
+	case TokenTypeBang:
+		c.emitOp(OpNot)
+	case TokenTypeMinus:
+		c.emitOp(OpNegate)
+	case TokenTypeTilde:
+		c.emitOp(OpBitNot)
+	default:
+		panic(fmt.Sprintf("unknown unary operator: %v", e.operator.ty))
+	}
+}
+
 type TernaryExpr struct {
 	cond  Expr
 	left  Expr
@@ -231,13 +237,26 @@ func (e TernaryExpr) Resolve(r *Resolver) {
 	e.right.Resolve(r)
 }
 
+func (e TernaryExpr) Compile(c *Compiler) {
+	e.cond.Compile(c)
+	elseJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	e.left.Compile(c)
+	endJump := c.emitJump(OpJump)
+	c.patchJump(elseJump)
+	c.emitOp(OpPop)
+	e.right.Compile(c)
+	c.patchJump(endJump)
+}
+
 type VariableExpr struct {
 	name     Token
 	distance *int
+	slot     *int
 }
 
 func (e VariableExpr) Evaluate(env *Environment) (Value, RuntimeException) {
-	return env.Get(*e.distance, e.name)
+	return env.Get(*e.distance, *e.slot, e.name)
 }
 
 func (e VariableExpr) Resolve(r *Resolver) {
@@ -250,34 +269,92 @@ func (e VariableExpr) Resolve(r *Resolver) {
 			),
 		)
 	}
-	distance := r.ResolveLocal(e.name)
-	*e.distance = distance
+	*e.distance, *e.slot = r.ResolveLocal(e.name)
+}
+
+func (e VariableExpr) Compile(c *Compiler) {
+	c.emitGetVariable(e.name)
 }
 
+// Assignments to variables, properties and indexes have an operator if
+// they are compound assignments (including ++ and --), in which case the
+// value stored is the result of applying the operator to the old value and
+// the new one. Postfix ++ and -- evaluate to the old value.
 type AssignExpr struct {
 	name     Token
+	operator *Token
 	value    Expr
+	postfix  bool
 	distance *int
+	slot     *int
+}
+
+// Returns the value to store for an assignment and the value the
+// assignment evaluates to, given the old value (nil unless it is a compound
+// assignment) and the value of the right hand side
+func assignedValue(operator *Token, postfix bool, old Value, value Value) (Value, Value, RuntimeException) {
+	if operator == nil {
+		return value, value, nil
+	}
+	store, err := binaryOp(*operator, old, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if postfix {
+		return store, old, nil
+	}
+	return store, store, nil
 }
 
 func (e AssignExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	var old Value
+	if e.operator != nil {
+		var err RuntimeException
+		old, err = env.Get(*e.distance, *e.slot, e.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	value, err := e.value.Evaluate(env)
 	if err != nil {
 		return nil, err
 	}
 
-	err = env.Assign(*e.distance, e.name, value)
+	store, result, err := assignedValue(e.operator, e.postfix, old, value)
 	if err != nil {
 		return nil, err
 	}
 
-	return value, nil
+	err = env.Assign(*e.distance, *e.slot, e.name, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 func (e AssignExpr) Resolve(r *Resolver) {
 	e.value.Resolve(r)
-	distance := r.ResolveLocal(e.name)
-	*e.distance = distance
+	*e.distance, *e.slot = r.ResolveLocal(e.name)
+}
+
+func (e AssignExpr) Compile(c *Compiler) {
+	if e.operator != nil {
+		c.emitGetVariable(e.name)
+		if e.postfix {
+			c.emitOp(OpPick)
+			c.emitByte(0)
+		}
+	}
+	e.value.Compile(c)
+	if e.operator != nil {
+		c.emitBinaryOp(*e.operator)
+	}
+	c.emitSetVariable(e.name)
+	if e.postfix {
+		c.emitOp(OpPop)
+	}
 }
 
 type LogicalExpr struct {
@@ -315,19 +392,78 @@ func (e LogicalExpr) Resolve(r *Resolver) {
 	e.right.Resolve(r)
 }
 
+func (e LogicalExpr) Compile(c *Compiler) {
+	e.left.Compile(c)
+
+	c.setPosition(e.operator)
+	switch e.operator.ty {
+	case TokenTypeAnd:
+		endJump := c.emitJump(OpJumpIfFalse)
+		c.emitOp(OpPop)
+		e.right.Compile(c)
+		c.patchJump(endJump)
+	case TokenTypeOr:
+		elseJump := c.emitJump(OpJumpIfFalse)
+		endJump := c.emitJump(OpJump)
+		c.patchJump(elseJump)
+		c.emitOp(OpPop)
+		e.right.Compile(c)
+		c.patchJump(endJump)
+	default:
+		panic(fmt.Sprintf("unknown logical operator: %v", e.operator.ty))
+	}
+}
+
+// Named arguments come after the positional ones, so the last len(names)
+// arguments are the values of the named ones.
 type CallExpr struct {
 	callee    Expr
 	paren     Token
 	arguments []Expr
+	names     []Token
 }
 
-func callLoxFn(fn *LoxFn, args []Value) (Value, RuntimeException) {
+// Calls a function on behalf of the code at site, which is used to trace
+// errors raised by the function back to the call. Calls may only be nested
+// as deeply as the interpreter allows, rather than until the Go stack runs
+// out.
+func callLoxFn(fn *LoxFn, args []Value, site Token) (Value, RuntimeException) {
 	declaration, env := fn.FnWithEnv()
 
-	calleeEnv := NewEnvironment(env)
-	for i, arg := range args {
-		name := declaration.parameters[i]
-		calleeEnv.Define(name, arg)
+	calls := env.ancestor(-1).calls
+	if calls.depth >= calls.max {
+		return nil, NewRuntimeError(site, "stack overflow")
+	}
+	calls.depth++
+	defer func() {
+		calls.depth--
+	}()
+
+	calleeEnv := NewEnvironment(env, *declaration.size)
+	fixed := len(declaration.parameters)
+	if declaration.variadic {
+		fixed--
+	}
+	for i := 0; i < fixed; i++ {
+		if i < len(args) && args[i] != nil {
+			calleeEnv.DefineAt(i, args[i])
+			continue
+		}
+		if declaration.defaults[i] == nil {
+			return nil, missingArgumentError(site, declaration.parameters[i].lexeme)
+		}
+		value, err := declaration.defaults[i].Evaluate(env)
+		if err != nil {
+			return nil, addStackFrame(err, describeFunction(fn.String(), fn.class), site.Position)
+		}
+		calleeEnv.DefineAt(i, value)
+	}
+	if declaration.variadic {
+		rest := []Value{}
+		if len(args) > fixed {
+			rest = append(rest, args[fixed:]...)
+		}
+		calleeEnv.DefineAt(fixed, NewList(rest))
 	}
 
 	var result Value = NewNil()
@@ -340,23 +476,23 @@ func callLoxFn(fn *LoxFn, args []Value) (Value, RuntimeException) {
 				result = ret.value
 				break
 			}
-			return nil, err
+			return nil, addStackFrame(err, describeFunction(fn.String(), fn.class), site.Position)
 		}
 	}
 
 	// Initializers act as if they return the instance
 	if fn.IsInit() {
-		return env.GetNative(0, "this"), nil
+		return env.GetAt(0, 0), nil
 	}
 
 	return result, nil
 }
 
-func newInstance(class *Class, args []Value) (Value, RuntimeException) {
+func newInstance(class *Class, args []Value, site Token) (Value, RuntimeException) {
 	instance := NewInstance(class)
 	initializer := instance.Initializer()
 	if initializer != nil {
-		return callLoxFn(*initializer, args)
+		return callLoxFn(initializer.(*LoxFn), args, site)
 	}
 	return instance, nil
 }
@@ -376,30 +512,47 @@ func (e CallExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 		args[i] = arg
 	}
 
+	if len(e.names) > 0 {
+		callable, ok := callee.(Callable)
+		if !ok {
+			return nil, NewRuntimeError(e.paren, "value is not callable")
+		}
+		names := make([]string, len(e.names))
+		for i, name := range e.names {
+			names[i] = name.lexeme
+		}
+		var message string
+		args, message = bindNamedArguments(callable, args, names)
+		if message != "" {
+			return nil, NewRuntimeError(e.paren, message)
+		}
+	}
+
+	return callValue(callee, args, e.paren)
+}
+
+func callValue(callee Value, args []Value, site Token) (Value, RuntimeException) {
 	callable, ok := callee.(Callable)
 	if !ok {
-		return nil, NewRuntimeError(e.paren, "value is not callable")
+		return nil, NewRuntimeError(site, "value is not callable")
 	}
 
-	arity := callable.Arity()
-	if arity != len(args) {
-		return nil, NewRuntimeError(
-			e.paren,
-			fmt.Sprintf(
-				"expected %d argument(s) but got %d",
-				arity,
-				len(args),
-			),
-		)
+	message := checkArity(callable, len(args))
+	if message != "" {
+		return nil, NewRuntimeError(site, message)
 	}
 
 	switch callable := callable.(type) {
 	case *NativeFn:
-		return callable.Fn()(args)
+		result, err := callable.Fn()(args)
+		if err != nil {
+			return nil, addStackFrame(locateError(err, site), callable.String(), site.Position)
+		}
+		return result, nil
 	case *LoxFn:
-		return callLoxFn(callable, args)
+		return callLoxFn(callable, args, site)
 	case *Class:
-		return newInstance(callable, args)
+		return newInstance(callable, args, site)
 	default:
 		panic("unreachable")
 	}
@@ -412,19 +565,78 @@ func (e CallExpr) Resolve(r *Resolver) {
 	}
 }
 
+func (e CallExpr) Compile(c *Compiler) {
+	e.callee.Compile(c)
+	for _, arg := range e.arguments {
+		arg.Compile(c)
+	}
+
+	c.setPosition(e.paren)
+	if len(e.names) == 0 {
+		c.emitOp(OpCall)
+		c.emitByte(byte(len(e.arguments)))
+		return
+	}
+
+	names := make([]Value, len(e.names))
+	for i, name := range e.names {
+		names[i] = NewString(name.lexeme)
+	}
+	c.emitOp(OpCallNamed)
+	c.emitByte(byte(len(e.arguments)))
+	c.emitShort(c.makeConstant(NewList(names)))
+}
+
+// Each parameter may have a default value, which is evaluated in the
+// environment the function was defined in whenever it is called without
+// that argument. If variadic is set, the last parameter is a rest
+// parameter, which holds a list of any arguments after the other
+// parameters.
 type FnExpr struct {
 	parameters []Token
+	defaults   []Expr
+	variadic   bool
 	body       []Stmt
+	size       *int
+}
+
+// Returns the names of the parameters other than the rest parameter
+func (e FnExpr) parameterNames() []string {
+	names := make([]string, 0, len(e.parameters))
+	for i, param := range e.parameters {
+		if !(e.variadic && i == len(e.parameters)-1) {
+			names = append(names, param.lexeme)
+		}
+	}
+	return names
+}
+
+func (e FnExpr) arity() (int, int) {
+	fixed := len(e.parameters)
+	maxArity := fixed
+	if e.variadic {
+		fixed--
+		maxArity = -1
+	}
+	minArity := 0
+	for minArity < fixed && e.defaults[minArity] == nil {
+		minArity++
+	}
+	return minArity, maxArity
 }
 
 func (e FnExpr) Evaluate(env *Environment) (Value, RuntimeException) {
-	return NewLoxFn(nil, e, env, false, false), nil
+	return NewLoxFn(nil, nil, e, env, false, false), nil
 }
 
 func (e FnExpr) Resolve(r *Resolver) {
 	r.ResolveFunction(e, FunctionTypeFunction)
 }
 
+func (e FnExpr) Compile(c *Compiler) {
+	c.function(e, nil, nil, FunctionTypeFunction, false)
+}
+
 type GetExpr struct {
 	object Expr
 	name   Token
@@ -440,18 +652,23 @@ func (e GetExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 	if !ok {
 		return nil, NewRuntimeError(
 			e.name,
-			"only classes and instances have properties",
+			fmt.Sprintf("%v values have no properties", object.Type()),
 		)
 	}
 
-	value, err := inst.Get(e.name)
+	return getProperty(inst, e.name)
+}
+
+// Gets a property, calling it if it is a property method
+func getProperty(inst Fielder, name Token) (Value, RuntimeException) {
+	value, err := inst.Get(name)
 	if err != nil {
 		return nil, err
 	}
 
 	fn, ok := value.(*LoxFn)
 	if ok && fn.IsProperty() {
-		return callLoxFn(fn, nil)
+		return callLoxFn(fn, nil, name)
 	}
 	return value, nil
 }
@@ -460,10 +677,18 @@ func (e GetExpr) Resolve(r *Resolver) {
 	e.object.Resolve(r)
 }
 
+func (e GetExpr) Compile(c *Compiler) {
+	e.object.Compile(c)
+	c.setPosition(e.name)
+	c.emitOpShort(OpGetProperty, c.identifierConstant(e.name.lexeme))
+}
+
 type SetExpr struct {
-	object Expr
-	name   Token
-	value  Expr
+	object   Expr
+	name     Token
+	operator *Token
+	value    Expr
+	postfix  bool
 }
 
 func (e SetExpr) Evaluate(env *Environment) (Value, RuntimeException) {
@@ -476,17 +701,33 @@ func (e SetExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 	if !ok {
 		return nil, NewRuntimeError(
 			e.name,
-			"only classes and instances have properties",
+			fmt.Sprintf("%v values have no properties", object.Type()),
 		)
 	}
 
+	var old Value
+	if e.operator != nil {
+		old, err = getProperty(inst, e.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	value, err := e.value.Evaluate(env)
 	if err != nil {
 		return nil, err
 	}
 
-	inst.Set(e.name, value)
-	return value, nil
+	store, result, err := assignedValue(e.operator, e.postfix, old, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inst.Set(e.name, store)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func (e SetExpr) Resolve(r *Resolver) {
@@ -494,13 +735,41 @@ func (e SetExpr) Resolve(r *Resolver) {
 	e.object.Resolve(r)
 }
 
+func (e SetExpr) Compile(c *Compiler) {
+	e.object.Compile(c)
+	if e.operator != nil {
+		// Keep the object around for the set
+		c.emitOp(OpPick)
+		c.emitByte(0)
+		c.setPosition(e.name)
+		c.emitOpShort(OpGetProperty, c.identifierConstant(e.name.lexeme))
+		if e.postfix {
+			// Leave a copy of the old value under the object
+			c.emitOp(OpPick)
+			c.emitByte(0)
+			c.emitOp(OpBury)
+			c.emitByte(2)
+		}
+	}
+	e.value.Compile(c)
+	if e.operator != nil {
+		c.emitBinaryOp(*e.operator)
+	}
+	c.setPosition(e.name)
+	c.emitOpShort(OpSetProperty, c.identifierConstant(e.name.lexeme))
+	if e.postfix {
+		c.emitOp(OpPop)
+	}
+}
+
 type ThisExpr struct {
 	keyword  Token
 	distance *int
+	slot     *int
 }
 
 func (e ThisExpr) Evaluate(env *Environment) (Value, RuntimeException) {
-	return env.GetNative(*e.distance, "this"), nil
+	return env.GetAt(*e.distance, *e.slot), nil
 }
 
 func (e ThisExpr) Resolve(r *Resolver) {
@@ -508,22 +777,28 @@ func (e ThisExpr) Resolve(r *Resolver) {
 	if ty != FunctionTypeMethod && ty != FunctionTypeInitializer {
 		r.AddError(e.keyword, "cannot use this outside method")
 	}
-	*e.distance = r.ResolveLocal(e.keyword)
+	*e.distance, *e.slot = r.ResolveLocal(e.keyword)
+}
+
+func (e ThisExpr) Compile(c *Compiler) {
+	c.emitGetVariable(e.keyword)
 }
 
 type SuperExpr struct {
 	keyword  Token
 	method   Token
 	distance *int
+	slot     *int
 }
 
 func (e SuperExpr) Evaluate(env *Environment) (Value, RuntimeException) {
-	superclass, err := env.Get(*e.distance, e.keyword)
+	superclass, err := env.Get(*e.distance, *e.slot, e.keyword)
 	if err != nil {
 		return nil, err
 	}
 
-	object := env.GetNative(*e.distance-1, "this")
+	// this is always the only variable in the scope just inside super's
+	object := env.GetAt(*e.distance-1, 0)
 
 	method, err := object.(*Instance).MethodAtClass(e.method, superclass.(*Class))
 	if err != nil {
@@ -531,7 +806,7 @@ func (e SuperExpr) Evaluate(env *Environment) (Value, RuntimeException) {
 	}
 
 	if method.IsProperty() {
-		return callLoxFn(method, nil)
+		return callLoxFn(method.(*LoxFn), nil, e.method)
 	}
 
 	return method, nil
@@ -542,5 +817,260 @@ func (e SuperExpr) Resolve(r *Resolver) {
 	if ty != FunctionTypeMethod && ty != FunctionTypeInitializer {
 		r.AddError(e.keyword, "cannot use super outside method")
 	}
-	*e.distance = r.ResolveLocal(e.keyword)
+	*e.distance, *e.slot = r.ResolveLocal(e.keyword)
+}
+
+func (e SuperExpr) Compile(c *Compiler) {
+	c.emitGetVariable(Token{
+		Position: e.keyword.Position,
+		ty:       TokenTypeThis,
+		lexeme:   "this",
+		literal:  nil,
+	})
+	c.emitGetVariable(e.keyword)
+	c.setPosition(e.method)
+	c.emitOpShort(OpGetSuper, c.identifierConstant(e.method.lexeme))
+}
+
+// "...${expr}..."; the first part is always the string before the first
+// interpolated expression
+type InterpolationExpr struct {
+	start Token
+	parts []Expr
+}
+
+func (e InterpolationExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	var sb strings.Builder
+	for _, partExpr := range e.parts {
+		part, err := partExpr.Evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(part.String())
+	}
+	return NewString(sb.String()), nil
+}
+
+func (e InterpolationExpr) Resolve(r *Resolver) {
+	for _, part := range e.parts {
+		part.Resolve(r)
+	}
+}
+
+func (e InterpolationExpr) Compile(c *Compiler) {
+	e.parts[0].Compile(c)
+	for _, part := range e.parts[1:] {
+		part.Compile(c)
+		c.setPosition(e.start)
+		c.emitOp(OpConcat)
+	}
+}
+
+type ListExpr struct {
+	bracket  Token
+	elements []Expr
+}
+
+func (e ListExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	elements := make([]Value, len(e.elements))
+	for i, elementExpr := range e.elements {
+		element, err := elementExpr.Evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = element
+	}
+	return NewList(elements), nil
+}
+
+func (e ListExpr) Resolve(r *Resolver) {
+	for _, element := range e.elements {
+		element.Resolve(r)
+	}
+}
+
+func (e ListExpr) Compile(c *Compiler) {
+	c.setPosition(e.bracket)
+	c.emitOp(OpList)
+	for _, element := range e.elements {
+		element.Compile(c)
+		c.emitOp(OpListAppend)
+	}
+}
+
+type IndexExpr struct {
+	object  Expr
+	bracket Token
+	index   Expr
+}
+
+func (e IndexExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	object, err := e.object.Evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := e.index.Evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return getIndex(object, e.bracket, index)
+}
+
+func getIndex(object Value, bracket Token, index Value) (Value, RuntimeException) {
+	indexer, ok := object.(Indexer)
+	if !ok {
+		return nil, NewRuntimeError(
+			bracket,
+			fmt.Sprintf("%v values cannot be indexed", object.Type()),
+		)
+	}
+
+	return indexer.GetIndex(bracket, index)
+}
+
+func (e IndexExpr) Resolve(r *Resolver) {
+	e.object.Resolve(r)
+	e.index.Resolve(r)
+}
+
+func (e IndexExpr) Compile(c *Compiler) {
+	e.object.Compile(c)
+	e.index.Compile(c)
+	c.setPosition(e.bracket)
+	c.emitOp(OpGetIndex)
+}
+
+type IndexSetExpr struct {
+	object   Expr
+	bracket  Token
+	index    Expr
+	operator *Token
+	value    Expr
+	postfix  bool
+}
+
+func (e IndexSetExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	object, err := e.object.Evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := e.index.Evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var old Value
+	if e.operator != nil {
+		old, err = getIndex(object, e.bracket, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := e.value.Evaluate(env)
+	if err != nil {
+		return nil, err
+	}
+
+	indexer, ok := object.(Indexer)
+	if !ok {
+		return nil, NewRuntimeError(
+			e.bracket,
+			fmt.Sprintf("%v values cannot be indexed", object.Type()),
+		)
+	}
+
+	store, result, err := assignedValue(e.operator, e.postfix, old, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexer.SetIndex(e.bracket, index, store)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (e IndexSetExpr) Resolve(r *Resolver) {
+	e.value.Resolve(r)
+	e.object.Resolve(r)
+	e.index.Resolve(r)
+}
+
+func (e IndexSetExpr) Compile(c *Compiler) {
+	e.object.Compile(c)
+	e.index.Compile(c)
+	if e.operator != nil {
+		// Keep the object and index around for the set
+		c.emitOp(OpPick)
+		c.emitByte(1)
+		c.emitOp(OpPick)
+		c.emitByte(1)
+		c.setPosition(e.bracket)
+		c.emitOp(OpGetIndex)
+		if e.postfix {
+			// Leave a copy of the old value under the object
+			c.emitOp(OpPick)
+			c.emitByte(0)
+			c.emitOp(OpBury)
+			c.emitByte(3)
+		}
+	}
+	e.value.Compile(c)
+	if e.operator != nil {
+		c.emitBinaryOp(*e.operator)
+	}
+	c.setPosition(e.bracket)
+	c.emitOp(OpSetIndex)
+	if e.postfix {
+		c.emitOp(OpPop)
+	}
+}
+
+type MapExpr struct {
+	brace  Token
+	keys   []Expr
+	values []Expr
+}
+
+func (e MapExpr) Evaluate(env *Environment) (Value, RuntimeException) {
+	m := NewMap()
+	for i := range e.keys {
+		key, err := e.keys[i].Evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := e.values[i].Evaluate(env)
+		if err != nil {
+			return nil, err
+		}
+
+		if !m.Insert(key, value) {
+			return nil, unhashableKeyError(e.brace, key)
+		}
+	}
+	return m, nil
+}
+
+func (e MapExpr) Resolve(r *Resolver) {
+	for i := range e.keys {
+		e.keys[i].Resolve(r)
+		e.values[i].Resolve(r)
+	}
+}
+
+func (e MapExpr) Compile(c *Compiler) {
+	c.setPosition(e.brace)
+	c.emitOp(OpMap)
+	for i := range e.keys {
+		e.keys[i].Compile(c)
+		e.values[i].Compile(c)
+		c.setPosition(e.brace)
+		c.emitOp(OpMapInsert)
+	}
 }
@@ -0,0 +1,139 @@
+package lox
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Implemented by Go types that Lox code can use like instances, such as a
+// request object or a database row that loads its fields lazily. Wrap one
+// with NewHost (FromGo does this automatically) to get a value that can be
+// passed to Lox code.
+//
+// GetProperty returns the value of the property or method with the given
+// name, or nil if there is no such property. Methods are just properties
+// holding functions, e.g. a *NativeFn or the result of WrapGoFunc on a
+// method value. A non-nil error becomes a runtime error in the Lox code.
+//
+// If the type implements fmt.Stringer, that is how it is printed; otherwise
+// it is printed as <object 'TypeName'>.
+type HostObject interface {
+	GetProperty(name string) (Value, error)
+}
+
+// Implemented by host objects whose properties Lox code can also set.
+// SetProperty should return an error if the property cannot be set.
+type MutableHostObject interface {
+	HostObject
+	SetProperty(name string, value Value) error
+}
+
+// object; a host object wrapped for use as a Lox value
+type Host struct {
+	object   HostObject
+	readOnly bool
+}
+
+// Wraps a host object. It is read-only unless it is a MutableHostObject.
+func NewHost(object HostObject) *Host {
+	_, mutable := object.(MutableHostObject)
+	return &Host{
+		object:   object,
+		readOnly: !mutable,
+	}
+}
+
+// Wraps a host object such that Lox code cannot set any of its properties,
+// even if it is a MutableHostObject
+func NewReadOnlyHost(object HostObject) *Host {
+	return &Host{
+		object:   object,
+		readOnly: true,
+	}
+}
+
+func (x *Host) Type() Type {
+	return TypeObject
+}
+
+func (x *Host) Bool() bool {
+	return true
+}
+
+// Two wrappers are equal if they wrap the same object
+func (x *Host) Equal(other Value) bool {
+	y, ok := other.(*Host)
+	if !ok {
+		return false
+	}
+	if x == y {
+		return true
+	}
+	if !reflect.TypeOf(x.object).Comparable() {
+		return false
+	}
+	return x.object == y.object
+}
+
+func (x *Host) Hash() (uint64, bool) {
+	v := reflect.ValueOf(x.object)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return hashUint64(uint64(v.Pointer())), true
+	default:
+		return 0, false
+	}
+}
+
+func (x *Host) String() string {
+	stringer, ok := x.object.(fmt.Stringer)
+	if ok {
+		return stringer.String()
+	}
+	ty := reflect.TypeOf(x.object)
+	for ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+	return fmt.Sprintf("<object '%s'>", ty.Name())
+}
+
+func (x *Host) Repr() string {
+	return x.String()
+}
+
+// Returns the wrapped host object
+func (x *Host) Object() HostObject {
+	return x.object
+}
+
+func (x *Host) ReadOnly() bool {
+	return x.readOnly
+}
+
+func (x *Host) Get(name Token) (Value, RuntimeException) {
+	value, err := x.object.GetProperty(name.lexeme)
+	if err != nil {
+		return nil, NewRuntimeError(name, err.Error())
+	}
+	if value == nil {
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("undefined property '%s' on %s", name.lexeme, x.String()),
+		)
+	}
+	return value, nil
+}
+
+func (x *Host) Set(name Token, value Value) RuntimeException {
+	if x.readOnly {
+		return NewRuntimeError(
+			name,
+			fmt.Sprintf("cannot set properties on read-only %s", x.String()),
+		)
+	}
+	err := x.object.(MutableHostObject).SetProperty(name.lexeme, value)
+	if err != nil {
+		return NewRuntimeError(name, err.Error())
+	}
+	return nil
+}
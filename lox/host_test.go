@@ -0,0 +1,177 @@
+package lox
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type hostCounter struct {
+	count int
+}
+
+func (c *hostCounter) GetProperty(name string) (Value, error) {
+	switch name {
+	case "count":
+		return NewInteger(int64(c.count)), nil
+	case "incr":
+		return WrapGoFunc(func() { c.count++ }), nil
+	case "add":
+		return WrapGoFunc(c.add), nil
+	case "broken":
+		return nil, fmt.Errorf("broken property")
+	}
+	return nil, nil
+}
+
+func (c *hostCounter) add(n int) int {
+	c.count += n
+	return c.count
+}
+
+func (c *hostCounter) SetProperty(name string, value Value) error {
+	if name != "count" {
+		return fmt.Errorf("cannot set '%s'", name)
+	}
+	return ToGo(value, &c.count)
+}
+
+func TestHost(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		counter := &hostCounter{}
+		in.SetGlobal("counter", NewHost(counter))
+
+		_, err := in.Eval(`
+			counter.incr();
+			counter.incr();
+			print counter.count;
+			counter.count = 10;
+			print counter;
+			try { counter.other = 1; } catch (e) { print e.message; }
+			try { counter.broken; } catch (e) { print e.message; }
+			try { counter.missing; } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "2\n<object 'hostCounter'>\ncannot set 'other'\nbroken property\n" +
+			"undefined property 'missing' on <object 'hostCounter'>\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+		if counter.count != 10 {
+			t.Errorf("%s: got count %d, want 10", engine.name, counter.count)
+		}
+	}
+}
+
+func TestReadOnlyHost(t *testing.T) {
+	for _, engine := range engines {
+		in := engine.new()
+		in.SetStdout(&bytes.Buffer{})
+		counter := &hostCounter{count: 1}
+		host := NewReadOnlyHost(counter)
+		if !host.ReadOnly() {
+			t.Errorf("NewReadOnlyHost gave a writable host")
+		}
+		in.SetGlobal("counter", host)
+
+		_, err := in.Eval("counter.count = 5;")
+		if err == nil || !strings.Contains(err.Error(), "cannot set properties on read-only <object 'hostCounter'>") {
+			t.Errorf("%s: got error %v", engine.name, err)
+		}
+		if counter.count != 1 {
+			t.Errorf("%s: read-only host was modified", engine.name)
+		}
+
+		// Methods can still change the object
+		if _, err := in.Eval("counter.incr();"); err != nil || counter.count != 2 {
+			t.Errorf("%s: got count %d and error %v", engine.name, counter.count, err)
+		}
+	}
+}
+
+type hostPoint struct {
+	x int
+}
+
+func (p hostPoint) GetProperty(name string) (Value, error) {
+	if name == "x" {
+		return NewInteger(int64(p.x)), nil
+	}
+	return nil, nil
+}
+
+func (p hostPoint) String() string {
+	return fmt.Sprintf("Point(%d)", p.x)
+}
+
+func TestHostWithoutSetter(t *testing.T) {
+	host := NewHost(hostPoint{x: 3})
+	if !host.ReadOnly() {
+		t.Errorf("host without SetProperty is writable")
+	}
+	if host.String() != "Point(3)" {
+		t.Errorf("got %s, want it printed with String", host.String())
+	}
+	if !host.Equal(NewHost(hostPoint{x: 3})) || host.Equal(NewHost(hostPoint{x: 4})) {
+		t.Errorf("hosts should be equal exactly when their objects are")
+	}
+
+	value, err := FromGo(hostPoint{x: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := value.(*Host); !ok {
+		t.Errorf("FromGo of a host object gave %v", value)
+	}
+}
+
+func TestHostValues(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		counter := &hostCounter{}
+		in.SetGlobal("counter", NewHost(counter))
+		in.SetGlobal("same", NewHost(counter))
+		in.SetGlobal("other", NewHost(&hostCounter{}))
+		in.SetGlobal("point", NewHost(hostPoint{x: 1}))
+
+		_, err := in.Eval(`
+			print counter.add(5);
+			var add = counter.add;
+			print add(2);
+			print counter == same;
+			print counter == other;
+			var names = {};
+			names[counter] = "counter";
+			print names[same];
+			print point.x;
+			if (point) print "truthy";
+			try { names[point] = 1; } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "5\n7\ntrue\nfalse\ncounter\n1\ntruthy\nobject values cannot be used as map keys\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+
+		// Host objects come back out as themselves
+		value, _ := in.GetGlobal("counter")
+		var back *hostCounter
+		if err := ToGo(value, &back); err != nil || back != counter {
+			t.Errorf("%s: ToGo gave %v, %v", engine.name, back, err)
+		}
+		var object HostObject
+		if err := ToGo(value, &object); err != nil || object != counter {
+			t.Errorf("%s: ToGo into a HostObject gave %v, %v", engine.name, object, err)
+		}
+	}
+}
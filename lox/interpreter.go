@@ -0,0 +1,293 @@
+package lox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Errors found in a piece of source before any of it was run, such as
+// syntax errors. Each error is a *SyntaxError, *ResolverError or
+// *CompileError.
+type ErrorList []error
+
+func (e ErrorList) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Runs Lox code on behalf of a Go program, using either the tree-walking
+// interpreter or the bytecode VM. Globals persist between calls, so the
+// same interpreter can run a sequence of snippets like a REPL would.
+// Interpreters share no state with each other, so separate ones may be used
+// from separate goroutines, but a single one may not.
+//
+// Errors are returned rather than printed: an ErrorList if the code could
+// not be run at all, or a *RuntimeError or *ThrowException if running it
+// failed.
+type Interpreter struct {
+	env         *Environment
+	vm          *VM
+	stderr      io.Writer
+	disassemble bool
+}
+
+func NewInterpreter() *Interpreter {
+	return newInterpreter(NewGlobalEnvironment(), nil)
+}
+
+func NewVMInterpreter() *Interpreter {
+	return newInterpreter(nil, NewVM())
+}
+
+func newInterpreter(env *Environment, vm *VM) *Interpreter {
+	in := &Interpreter{
+		env:         env,
+		vm:          vm,
+		stderr:      os.Stderr,
+		disassemble: false,
+	}
+	in.SetGlobal("readLine", NewNativeFn(0, "readLine", in.streams().readLine))
+	return in
+}
+
+func (in *Interpreter) streams() *streams {
+	if in.vm != nil {
+		return in.vm.streams
+	}
+	return in.env.streams
+}
+
+// Sets where print statements write to
+func (in *Interpreter) SetStdout(w io.Writer) {
+	in.streams().stdout = w
+}
+
+// Sets where readLine() reads from
+func (in *Interpreter) SetStdin(r io.Reader) {
+	in.streams().stdin = bufio.NewReader(r)
+}
+
+// Sets where diagnostic output (such as disassembly) is written to
+func (in *Interpreter) SetStderr(w io.Writer) {
+	in.stderr = w
+}
+
+// Sets how deeply calls may be nested before a "stack overflow" runtime
+// error is raised, which is 100000 by default. The tree-walking interpreter
+// recurses on the Go stack, so a lower limit may be needed to stop code with
+// large functions from crashing the program instead.
+func (in *Interpreter) SetMaxCallDepth(depth int) {
+	if in.vm != nil {
+		in.vm.maxCallDepth = depth
+	} else {
+		in.env.calls.max = depth
+	}
+}
+
+// Sets whether to write the bytecode for each piece of code to stderr
+// before running it. Has no effect when not using the VM.
+func (in *Interpreter) SetDisassemble(disassemble bool) {
+	in.disassemble = disassemble
+}
+
+// Defines a global, which is also visible to (but not exported by) every
+// module imported from then on. An anonymous native fn, such as one made by
+// WrapGoFunc from a function literal, is defined as a copy named after the
+// global.
+func (in *Interpreter) SetGlobal(name string, value Value) {
+	native, ok := value.(*NativeFn)
+	if ok && native.name == "" {
+		named := *native
+		named.name = name
+		value = &named
+	}
+	if in.vm != nil {
+		in.vm.DefineNative(name, value)
+	} else {
+		in.env.DefineNative(name, value)
+	}
+}
+
+// Returns the value of a global, or false if it is not defined or has not
+// been initialized
+func (in *Interpreter) GetGlobal(name string) (Value, bool) {
+	var value Value
+	if in.vm != nil {
+		value = in.vm.main.globals[name]
+	} else {
+		value = in.env.globals[name]
+	}
+	return value, value != nil
+}
+
+// Runs a piece of code. If it is a single expression rather than a list of
+// statements, returns the value of the expression; otherwise returns nil.
+func (in *Interpreter) Eval(source string) (Value, error) {
+	return in.run("<input>", source, true)
+}
+
+// Runs a file, which any imports in it are relative to
+func (in *Interpreter) RunFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if in.vm != nil {
+		in.vm.SetScriptPath(path)
+	} else {
+		in.env.SetScriptPath(path)
+	}
+	_, err = in.run(path, string(content), false)
+	return err
+}
+
+// Calls a function, class or other callable value. A nil argument is
+// passed as a Lox nil.
+func (in *Interpreter) Call(fn Value, args ...Value) (Value, error) {
+	for i, arg := range args {
+		if arg == nil {
+			args[i] = NewNil()
+		}
+	}
+
+	var result Value
+	var err RuntimeException
+	if in.vm != nil {
+		result, err = in.vm.Call(fn, args)
+	} else {
+		result, err = callValue(fn, args, Token{})
+	}
+	if err != nil {
+		return nil, err.(error)
+	}
+	return result, nil
+}
+
+func (in *Interpreter) run(name string, source string, allowExpr bool) (Value, error) {
+	scanner := NewScanner(name, source)
+	tokens, serrs := scanner.ScanTokens()
+	if len(serrs) > 0 {
+		errs := make(ErrorList, len(serrs))
+		for i, err := range serrs {
+			errs[i] = err
+		}
+		return nil, errs
+	}
+
+	parser := NewParser(tokens)
+	stmts, perrs := parser.ParseStatements()
+	if len(perrs) > 0 {
+		if allowExpr {
+			// Try to parse as an expression. If that works, then
+			// return the result of evaluating the expression
+			// instead of trying to get a full statement. If neither
+			// work, then still return the errors from trying to
+			// parse as a statement.
+			parser := NewParser(tokens)
+			expr, eerrs := parser.ParseExpression()
+			if len(eerrs) == 0 {
+				resolver := NewResolver()
+				rerrs := resolver.ResolveExpression(expr)
+				if len(rerrs) > 0 {
+					errs := make(ErrorList, len(rerrs))
+					for i, err := range rerrs {
+						errs[i] = err
+					}
+					return nil, errs
+				}
+				return in.evaluate(expr)
+			}
+		}
+
+		errs := make(ErrorList, len(perrs))
+		for i, err := range perrs {
+			errs[i] = err
+		}
+		return nil, errs
+	}
+
+	resolver := NewResolver()
+	rerrs := resolver.ResolveStatements(stmts)
+	if len(rerrs) > 0 {
+		errs := make(ErrorList, len(rerrs))
+		for i, err := range rerrs {
+			errs[i] = err
+		}
+		return nil, errs
+	}
+
+	return nil, in.execute(stmts)
+}
+
+func (in *Interpreter) compile(stmts []Stmt, expr Expr) (*FnProto, error) {
+	compiler := NewCompiler()
+	var proto *FnProto
+	var cerrs []*CompileError
+	if expr != nil {
+		proto, cerrs = compiler.CompileExpression(expr)
+	} else {
+		proto, cerrs = compiler.CompileStatements(stmts)
+	}
+	if len(cerrs) > 0 {
+		errs := make(ErrorList, len(cerrs))
+		for i, err := range cerrs {
+			errs[i] = err
+		}
+		return nil, errs
+	}
+
+	if in.disassemble {
+		fmt.Fprint(in.stderr, proto.Disassemble())
+	}
+	return proto, nil
+}
+
+func (in *Interpreter) execute(stmts []Stmt) error {
+	var err RuntimeException
+	if in.vm != nil {
+		proto, cerr := in.compile(stmts, nil)
+		if cerr != nil {
+			return cerr
+		}
+		_, err = in.vm.Interpret(proto)
+	} else {
+		for _, stmt := range stmts {
+			err = stmt.Execute(in.env)
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+func (in *Interpreter) evaluate(expr Expr) (Value, error) {
+	var value Value
+	var err RuntimeException
+	if in.vm != nil {
+		proto, cerr := in.compile(nil, expr)
+		if cerr != nil {
+			return nil, cerr
+		}
+		value, err = in.vm.Interpret(proto)
+	} else {
+		value, err = expr.Evaluate(in.env)
+	}
+
+	if err != nil {
+		return nil, err.(error)
+	}
+	return value, nil
+}
@@ -0,0 +1,207 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var engines = []struct {
+	name string
+	new  func() *Interpreter
+}{
+	{"interpreter", NewInterpreter},
+	{"vm", NewVMInterpreter},
+}
+
+// Runs source with a fresh interpreter, returning what it printed and the
+// error, if any
+func runScript(newInterpreter func() *Interpreter, source string) (string, error) {
+	var stdout bytes.Buffer
+	in := newInterpreter()
+	in.SetStdout(&stdout)
+	_, err := in.Eval(source)
+	return stdout.String(), err
+}
+
+// A script that should print output when run, or fail with an error
+// containing err if it is set
+type scriptTest struct {
+	name   string
+	source string
+	output string
+	err    string
+}
+
+// Runs each script with both engines, checking that they agree with the
+// test and with each other, down to the text of any error
+func runScriptTests(t *testing.T, tests []scriptTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var outputs []string
+			for _, engine := range engines {
+				output, err := runScript(engine.new, test.source)
+				if test.err == "" && err != nil {
+					t.Errorf("%s: unexpected error: %v", engine.name, err)
+				} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Errorf("%s: got error %v, want one containing %q", engine.name, err, test.err)
+				}
+				if test.err == "" && output != test.output {
+					t.Errorf("%s: got output %q, want %q", engine.name, output, test.output)
+				}
+				message := ""
+				if err != nil {
+					message = err.Error()
+				}
+				outputs = append(outputs, output+message)
+			}
+			if outputs[0] != outputs[1] {
+				t.Errorf("engines disagree:\n%s\n---\n%s", outputs[0], outputs[1])
+			}
+		})
+	}
+}
+
+var scriptTests = []scriptTest{
+	{
+		name: "stack overflow",
+		source: `
+			fun f(n) { return f(n + 1); }
+			f(0);
+		`,
+		err: "stack overflow",
+	},
+}
+
+func TestScripts(t *testing.T) {
+	runScriptTests(t, scriptTests)
+}
+
+func TestEval(t *testing.T) {
+	for _, engine := range engines {
+		in := engine.new()
+		if _, err := in.Eval("var x = 20;"); err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		value, err := in.Eval("x * 2 + 2")
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		if !value.Equal(NewInteger(42)) {
+			t.Errorf("%s: got %v, want 42", engine.name, value)
+		}
+
+		_, err = in.Eval("print ;")
+		var list ErrorList
+		if !errors.As(err, &list) {
+			t.Errorf("%s: got %v, want an ErrorList", engine.name, err)
+		}
+		_, err = in.Eval("nil.x;")
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("%s: got %v, want a *RuntimeError", engine.name, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetGlobal("answer", NewInteger(41))
+		in.SetGlobal("greet", WrapGoFunc(func(name string) string { return "hi " + name }))
+		if _, err := in.Eval("print greet; print greet(\"lox\"); answer = answer + 1; var later = [answer];"); err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		if got, want := stdout.String(), "<native fn 'greet'>\nhi lox\n"; got != want {
+			t.Errorf("%s: got output %q, want %q", engine.name, got, want)
+		}
+
+		if value, ok := in.GetGlobal("answer"); !ok || !value.Equal(NewInteger(42)) {
+			t.Errorf("%s: answer = %v, %v", engine.name, value, ok)
+		}
+		if value, ok := in.GetGlobal("later"); !ok || value.Repr() != "[42]" {
+			t.Errorf("%s: later = %v, %v", engine.name, value, ok)
+		}
+		if value, ok := in.GetGlobal("missing"); ok {
+			t.Errorf("%s: got %v for an undefined global", engine.name, value)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, engine := range engines {
+		in := engine.new()
+		_, err := in.Eval(`
+			fun add(a, b = 10) { return a + b; }
+			fun isNil(x) { return x == nil; }
+			fun fail() { nil.x; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		add, _ := in.GetGlobal("add")
+		isNil, _ := in.GetGlobal("isNil")
+		fail, _ := in.GetGlobal("fail")
+
+		value, err := in.Call(add, NewInteger(1), NewInteger(2))
+		if err != nil || !value.Equal(NewInteger(3)) {
+			t.Errorf("%s: add(1, 2) = %v, %v", engine.name, value, err)
+		}
+		value, err = in.Call(add, NewInteger(1))
+		if err != nil || !value.Equal(NewInteger(11)) {
+			t.Errorf("%s: add(1) = %v, %v", engine.name, value, err)
+		}
+		value, err = in.Call(isNil, nil)
+		if err != nil || !value.Equal(NewBool(true)) {
+			t.Errorf("%s: isNil(nil) = %v, %v", engine.name, value, err)
+		}
+		if _, err = in.Call(add); err == nil {
+			t.Errorf("%s: add() succeeded", engine.name)
+		}
+		if _, err = in.Call(NewInteger(1)); err == nil {
+			t.Errorf("%s: calling an integer succeeded", engine.name)
+		}
+
+		_, err = in.Call(fail)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: got %v, want a *RuntimeError", engine.name, err)
+		}
+		if len(runtimeErr.Trace()) == 0 || runtimeErr.Trace()[0].Function() != "<fn 'fail'>" {
+			t.Errorf("%s: got trace %v, want it to start in fail", engine.name, runtimeErr.Trace())
+		}
+
+		// The interpreter should still work after an error
+		value, err = in.Eval("add(2, 2)")
+		if err != nil || !value.Equal(NewInteger(4)) {
+			t.Errorf("%s: add(2, 2) = %v, %v", engine.name, value, err)
+		}
+	}
+}
+
+func TestSetMaxCallDepth(t *testing.T) {
+	for _, engine := range engines {
+		in := engine.new()
+		_, err := in.Eval("fun sum(n) { if (n == 0) return 0; return n + sum(n - 1); }")
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		value, err := in.Eval("sum(10000)")
+		if err != nil || !value.Equal(NewInteger(50005000)) {
+			t.Errorf("%s: sum(10000) = %v, %v", engine.name, value, err)
+		}
+
+		// sum(n) nests n + 1 calls
+		in.SetMaxCallDepth(10)
+		if _, err := in.Eval("sum(9)"); err != nil {
+			t.Errorf("%s: sum(9): %v", engine.name, err)
+		}
+		_, err = in.Eval("sum(10)")
+		if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+			t.Errorf("%s: got %v, want a stack overflow", engine.name, err)
+		}
+	}
+}
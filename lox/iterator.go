@@ -0,0 +1,182 @@
+package lox
+
+import (
+	"fmt"
+)
+
+// iterator; steps through the elements of a list, the entries of a map or
+// the characters of a string. It has the done property and next method that
+// for-in loops expect of an iterator, so it can stand in for one written in
+// Lox, and its iter method returns the iterator itself.
+type Iterator struct {
+	step  func() Value
+	value Value
+	ended bool
+}
+
+// Creates an iterator that gets each value by calling step, which returns
+// nil once there are no more
+func newIterator(step func() Value) *Iterator {
+	return &Iterator{
+		step:  step,
+		value: nil,
+		ended: false,
+	}
+}
+
+// Returns an iterator over a list, map or string, or false if the value is
+// none of those. Lists produce their elements, maps their keys and strings
+// their characters, unless pairs is set, in which case each value is an
+// [index, element], [key, value] or [index, character] list.
+func builtinIterator(iterable Value, pairs bool) (*Iterator, bool) {
+	switch iterable := iterable.(type) {
+	case *List:
+		return iterable.iterator(pairs), true
+	case *Map:
+		return iterable.iterator(pairs), true
+	case String:
+		return iterable.iterator(pairs), true
+	default:
+		return nil, false
+	}
+}
+
+// Elements appended while iterating are included
+func (x *List) iterator(pairs bool) *Iterator {
+	i := 0
+	return newIterator(func() Value {
+		if i >= len(x.elements) {
+			return nil
+		}
+		value := x.elements[i]
+		if pairs {
+			value = NewList([]Value{NewInteger(int64(i)), value})
+		}
+		i++
+		return value
+	})
+}
+
+// Entries inserted while iterating are not included, and entries deleted
+// before they are reached are skipped
+func (x *Map) iterator(pairs bool) *Iterator {
+	entries := make([]*mapEntry, 0, x.count)
+	for _, entry := range x.order {
+		if !entry.deleted {
+			entries = append(entries, entry)
+		}
+	}
+
+	i := 0
+	return newIterator(func() Value {
+		for i < len(entries) && entries[i].deleted {
+			i++
+		}
+		if i >= len(entries) {
+			return nil
+		}
+		entry := entries[i]
+		i++
+		if pairs {
+			return NewList([]Value{entry.key, entry.value})
+		}
+		return entry.key
+	})
+}
+
+func (x String) iterator(pairs bool) *Iterator {
+	chars := []rune(x.value)
+	i := 0
+	return newIterator(func() Value {
+		if i >= len(chars) {
+			return nil
+		}
+		var value Value = NewString(string(chars[i]))
+		if pairs {
+			value = NewList([]Value{NewInteger(int64(i)), value})
+		}
+		i++
+		return value
+	})
+}
+
+func (x *Iterator) Type() Type {
+	return TypeIterator
+}
+
+func (x *Iterator) Bool() bool {
+	return true
+}
+
+func (x *Iterator) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *Iterator) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *Iterator) String() string {
+	return "<iterator>"
+}
+
+func (x *Iterator) Repr() string {
+	return x.String()
+}
+
+// Returns the value next will return, or nil if there are no more
+func (x *Iterator) peek() Value {
+	if x.value == nil && !x.ended {
+		x.value = x.step()
+		x.ended = x.value == nil
+	}
+	return x.value
+}
+
+func (x *Iterator) Get(name Token) (Value, RuntimeException) {
+	switch name.lexeme {
+	case "done":
+		return NewBool(x.peek() == nil), nil
+	case "next":
+		return NewNativeFn(0, "next", func(args []Value) (Value, RuntimeException) {
+			value := x.peek()
+			if value == nil {
+				return nil, NewRuntimeError(name, "iterator has no more values")
+			}
+			x.value = nil
+			return value, nil
+		}), nil
+	case "iter":
+		return NewNativeFn(0, "iter", func(args []Value) (Value, RuntimeException) {
+			return x, nil
+		}), nil
+	default:
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("undefined iterator property '%s'", name.lexeme),
+		)
+	}
+}
+
+func (x *Iterator) Set(name Token, value Value) RuntimeException {
+	return NewRuntimeError(name, "cannot set properties on an iterator")
+}
+
+// Splits a value produced for a for-in loop with two variables
+func unpackPair(token Token, value Value) (Value, Value, RuntimeException) {
+	list, ok := value.(*List)
+	if !ok || len(list.elements) != 2 {
+		return nil, nil, NewRuntimeError(
+			token,
+			fmt.Sprintf("expected a list of 2 values for the loop variables, got %s", value.Repr()),
+		)
+	}
+	return list.elements[0], list.elements[1], nil
+}
+
+func notIterableError(token Token, value Value) *RuntimeError {
+	return NewRuntimeError(
+		token,
+		fmt.Sprintf("%v values are not iterable", value.Type()),
+	)
+}
@@ -0,0 +1,106 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestForIn(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "builtin iterables",
+			source: `
+				for (x in [1, 2]) print x;
+				for (i, x in ["a", "b"]) print "${i}${x}";
+				for (k in {"a": 1, "b": 2}) print k;
+				for (k, v in {"a": 1}) print k + v;
+				for (c in "hé") print c;
+				for (i, c in "hé") print "${i}${c}";
+				for (x in []) print x;
+			`,
+			output: "1\n2\n0a\n1b\na\nb\na1\nh\né\n0h\n1é\n",
+		},
+		{
+			name: "lox iterators",
+			source: `
+				class Range {
+					init(n) { this.n = n; this.i = 0; this.done = n == 0; }
+					iter() { return this; }
+					next() { this.i = this.i + 1; this.done = this.i == this.n; return this.i; }
+				}
+				for (x in Range(2)) print x;
+				for (x in Range(0)) print x;
+				class Pairs {
+					iter() { return [[1, 2], [3, 4]].iter(); }
+				}
+				for (a, b in Pairs()) print a + b;
+				var in = "in";
+				print in;
+			`,
+			output: "1\n2\n3\n7\nin\n",
+		},
+		{
+			name: "builtin iterators",
+			source: `
+				var it = [1, 2].iter();
+				print it;
+				print it.iter() == it;
+				print it.done;
+				print it.next();
+				print it.next();
+				print it.done;
+				try { it.next(); } catch (e) { print e.message; }
+				try { it.other; } catch (e) { print e.message; }
+			`,
+			output: "<iterator>\ntrue\nfalse\n1\n2\ntrue\niterator has no more values\n" +
+				"undefined iterator property 'other'\n",
+		},
+		{
+			name: "changes while iterating",
+			source: `
+				var xs = [1];
+				for (x in xs) {
+					if (x < 3) xs.push(x + 1);
+					print x;
+				}
+				var m = {"a": 1, "b": 2, "c": 3};
+				for (k in m) {
+					m.delete("b");
+					m["d"] = 4;
+					print k;
+				}
+			`,
+			output: "1\n2\n3\na\nc\n",
+		},
+		{
+			name: "break, continue and closures",
+			source: `
+				var fns = [];
+				for (x in [1, 2, 3, 4]) {
+					if (x == 2) continue;
+					if (x == 4) break;
+					fun get() { return x; }
+					fns.push(get);
+				}
+				for (f in fns) print f();
+			`,
+			output: "1\n3\n",
+		},
+		{
+			name: "errors",
+			source: `
+				try { for (x in 1) print x; } catch (e) { print e.message; }
+				class Values {
+					init(values) { this.values = values; }
+					iter() { return this.values.iter(); }
+				}
+				try { for (a, b in Values([1])) print a + b; } catch (e) { print e.message; }
+				try { for (a, b in Values([[1, 2, 3]])) print a + b; } catch (e) { print e.message; }
+				try { [].iter().done = true; } catch (e) { print e.message; }
+			`,
+			output: "integer values are not iterable\n" +
+				"expected a list of 2 values for the loop variables, got 1\n" +
+				"expected a list of 2 values for the loop variables, got [1, 2, 3]\n" +
+				"cannot set properties on an iterator\n",
+		},
+	})
+}
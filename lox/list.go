@@ -0,0 +1,200 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// list
+type List struct {
+	elements []Value
+}
+
+func NewList(elements []Value) *List {
+	return &List{elements: elements}
+}
+
+func (x *List) Type() Type {
+	return TypeList
+}
+
+func (x *List) Bool() bool {
+	return true
+}
+
+func (x *List) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *List) Hash() (uint64, bool) {
+	return 0, false
+}
+
+func (x *List) String() string {
+	return x.repr(nil)
+}
+
+// Prints the list given the lists being printed that contain it, printing
+// [...] instead of recursing forever if it contains itself
+func (x *List) repr(printing []Value) string {
+	if isPrinting(printing, x) {
+		return "[...]"
+	}
+	printing = append(printing, x)
+	parts := make([]string, len(x.elements))
+	for i, element := range x.elements {
+		parts[i] = reprElement(element, printing)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Returns the representation of a value inside a container, guarding
+// against cycles through the containers already being printed
+func reprElement(value Value, printing []Value) string {
+	switch value := value.(type) {
+	case *List:
+		return value.repr(printing)
+	case *Map:
+		return value.repr(printing)
+	default:
+		return value.Repr()
+	}
+}
+
+func isPrinting(printing []Value, container Value) bool {
+	for _, other := range printing {
+		if other == container {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *List) Repr() string {
+	return x.String()
+}
+
+func (x *List) Elements() []Value {
+	return x.elements
+}
+
+func (x *List) Append(value Value) {
+	x.elements = append(x.elements, value)
+}
+
+// Converts a value to an index into a sequence of the given length. If
+// inclusive is true, the length itself is also a valid index. Like the
+// bitwise operators, accepts floats with no fractional part.
+func toIndex(token Token, index Value, length int, inclusive bool) (int, RuntimeException) {
+	whole, ok := wholeInteger(index)
+	if !ok {
+		return 0, NewRuntimeError(token, "list index must be a whole number")
+	}
+
+	// A BigInteger is always out of range
+	num, ok := whole.(Integer)
+	i := num.value
+	if !ok || i < 0 || i > int64(length) || (i == int64(length) && !inclusive) {
+		return 0, NewRuntimeError(
+			token,
+			fmt.Sprintf("list index %v out of range", index),
+		)
+	}
+	return int(i), nil
+}
+
+func (x *List) GetIndex(bracket Token, index Value) (Value, RuntimeException) {
+	i, err := toIndex(bracket, index, len(x.elements), false)
+	if err != nil {
+		return nil, err
+	}
+	return x.elements[i], nil
+}
+
+func (x *List) SetIndex(bracket Token, index Value, value Value) RuntimeException {
+	i, err := toIndex(bracket, index, len(x.elements), false)
+	if err != nil {
+		return err
+	}
+	x.elements[i] = value
+	return nil
+}
+
+type listMethod struct {
+	arity int
+	fn    func(x *List, name Token, args []Value) (Value, RuntimeException)
+}
+
+var listMethods = map[string]listMethod{
+	"len": {0, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		return NewInteger(int64(len(x.elements))), nil
+	}},
+	"push": {1, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		x.elements = append(x.elements, args[0])
+		return NewNil(), nil
+	}},
+	"pop": {0, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		if len(x.elements) == 0 {
+			return nil, NewRuntimeError(name, "pop from empty list")
+		}
+		last := x.elements[len(x.elements)-1]
+		x.elements = x.elements[:len(x.elements)-1]
+		return last, nil
+	}},
+	"insert": {2, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		i, err := toIndex(name, args[0], len(x.elements), true)
+		if err != nil {
+			return nil, err
+		}
+		x.elements = append(x.elements, nil)
+		copy(x.elements[i+1:], x.elements[i:])
+		x.elements[i] = args[1]
+		return NewNil(), nil
+	}},
+	"remove": {1, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		i, err := toIndex(name, args[0], len(x.elements), false)
+		if err != nil {
+			return nil, err
+		}
+		removed := x.elements[i]
+		x.elements = append(x.elements[:i], x.elements[i+1:]...)
+		return removed, nil
+	}},
+	"iter": {0, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		return x.iterator(false), nil
+	}},
+	"slice": {2, func(x *List, name Token, args []Value) (Value, RuntimeException) {
+		start, err := toIndex(name, args[0], len(x.elements), true)
+		if err != nil {
+			return nil, err
+		}
+		end, err := toIndex(name, args[1], len(x.elements), true)
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, NewRuntimeError(name, "slice start must not exceed end")
+		}
+		elements := make([]Value, end-start)
+		copy(elements, x.elements[start:end])
+		return NewList(elements), nil
+	}},
+}
+
+func (x *List) Get(name Token) (Value, RuntimeException) {
+	method, ok := listMethods[name.lexeme]
+	if !ok {
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("undefined list method '%s'", name.lexeme),
+		)
+	}
+
+	return NewNativeFn(method.arity, name.lexeme, func(args []Value) (Value, RuntimeException) {
+		return method.fn(x, name, args)
+	}), nil
+}
+
+func (x *List) Set(name Token, value Value) RuntimeException {
+	return NewRuntimeError(name, "cannot set properties on a list")
+}
@@ -0,0 +1,67 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "methods",
+			source: `
+				var xs = [1, 2, 3];
+				xs.push(4);
+				xs[0] = 0;
+				print xs;
+				print xs.len();
+				print xs.pop();
+				print xs.slice(1, 3);
+				xs.insert(0, "a");
+				xs.insert(xs.len(), "z");
+				print xs;
+				print xs.remove(1);
+				print xs;
+				print [];
+			`,
+			output: "[0, 2, 3, 4]\n4\n4\n[2, 3]\n[\"a\", 0, 2, 3, \"z\"]\n0\n[\"a\", 2, 3, \"z\"]\n[]\n",
+		},
+		{
+			name: "equality and nesting",
+			source: `
+				var xs = [1, [2, 3]];
+				print xs[1][0];
+				xs[1][1] = "b";
+				print xs;
+				print xs == xs;
+				print [1] == [1];
+				var ys = [1];
+				ys.push(ys);
+				print ys;
+			`,
+			output: "2\n[1, [2, \"b\"]]\ntrue\nfalse\n[1, [...]]\n",
+		},
+		{
+			name: "errors",
+			source: `
+				var xs = [1, 2];
+				try { xs[2]; } catch (e) { print e.message; }
+				try { xs[-1] = 0; } catch (e) { print e.message; }
+				try { xs["0"]; } catch (e) { print e.message; }
+				try { xs.remove(2); } catch (e) { print e.message; }
+				try { xs.slice(2, 1); } catch (e) { print e.message; }
+				try { [].pop(); } catch (e) { print e.message; }
+				try { xs.sort(); } catch (e) { print e.message; }
+				try { xs.push(); } catch (e) { print e.message; }
+			`,
+			output: "list index 2 out of range\nlist index -1 out of range\n" +
+				"list index must be a whole number\nlist index 2 out of range\n" +
+				"slice start must not exceed end\npop from empty list\n" +
+				"undefined list method 'sort'\nexpected 1 argument(s) but got 0\n",
+		},
+		{
+			name:   "uncaught index error",
+			source: "var xs = [];\nprint xs[0];",
+			err:    "<input>:2:11: runtime error: list index 0 out of range",
+		},
+	})
+}
@@ -0,0 +1,256 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+type mapEntry struct {
+	key     Value
+	value   Value
+	deleted bool
+}
+
+// map; iterates in insertion order
+type Map struct {
+	buckets map[uint64][]*mapEntry
+	order   []*mapEntry
+	count   int
+}
+
+func NewMap() *Map {
+	return &Map{
+		buckets: map[uint64][]*mapEntry{},
+		order:   []*mapEntry{},
+		count:   0,
+	}
+}
+
+func (x *Map) Type() Type {
+	return TypeMap
+}
+
+func (x *Map) Bool() bool {
+	return true
+}
+
+func (x *Map) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *Map) Hash() (uint64, bool) {
+	return 0, false
+}
+
+func (x *Map) String() string {
+	return x.repr(nil)
+}
+
+// Prints the map like List.repr, printing {...} if it contains itself
+func (x *Map) repr(printing []Value) string {
+	if isPrinting(printing, x) {
+		return "{...}"
+	}
+	printing = append(printing, x)
+	parts := make([]string, 0, x.count)
+	for _, entry := range x.order {
+		if !entry.deleted {
+			key := reprElement(entry.key, printing)
+			value := reprElement(entry.value, printing)
+			parts = append(parts, key+": "+value)
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (x *Map) Repr() string {
+	return x.String()
+}
+
+func (x *Map) Len() int {
+	return x.count
+}
+
+func (x *Map) find(key Value) (uint64, *mapEntry, bool) {
+	hash, ok := key.Hash()
+	if !ok {
+		return 0, nil, false
+	}
+	for _, entry := range x.buckets[hash] {
+		if entry.key.Equal(key) {
+			return hash, entry, true
+		}
+	}
+	return hash, nil, true
+}
+
+func unhashableKeyError(token Token, key Value) *RuntimeError {
+	return NewRuntimeError(
+		token,
+		fmt.Sprintf("%v values cannot be used as map keys", key.Type()),
+	)
+}
+
+// Looks up a key, returning nil if it is not present in the map. Returns
+// false if the key is not hashable.
+func (x *Map) Lookup(key Value) (Value, bool) {
+	_, entry, ok := x.find(key)
+	if !ok {
+		return nil, false
+	}
+	if entry == nil {
+		return nil, true
+	}
+	return entry.value, true
+}
+
+// Inserts or replaces a key. Returns false if the key is not hashable.
+func (x *Map) Insert(key Value, value Value) bool {
+	hash, entry, ok := x.find(key)
+	if !ok {
+		return false
+	}
+	if entry != nil {
+		entry.value = value
+		return true
+	}
+
+	entry = &mapEntry{key: key, value: value, deleted: false}
+	x.buckets[hash] = append(x.buckets[hash], entry)
+	x.order = append(x.order, entry)
+	x.count++
+	return true
+}
+
+// Removes a key, returning whether it was present. Returns false if the
+// key is not hashable.
+func (x *Map) Delete(key Value) (bool, bool) {
+	hash, entry, ok := x.find(key)
+	if !ok {
+		return false, false
+	}
+	if entry == nil {
+		return false, true
+	}
+
+	bucket := x.buckets[hash]
+	for i, e := range bucket {
+		if e == entry {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(x.buckets, hash)
+	} else {
+		x.buckets[hash] = bucket
+	}
+
+	entry.deleted = true
+	x.count--
+
+	// Compact the iteration order once it's mostly deleted entries
+	if len(x.order) > 2*x.count+8 {
+		order := make([]*mapEntry, 0, x.count)
+		for _, e := range x.order {
+			if !e.deleted {
+				order = append(order, e)
+			}
+		}
+		x.order = order
+	}
+	return true, true
+}
+
+func (x *Map) Keys() []Value {
+	keys := make([]Value, 0, x.count)
+	for _, entry := range x.order {
+		if !entry.deleted {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+func (x *Map) Values() []Value {
+	values := make([]Value, 0, x.count)
+	for _, entry := range x.order {
+		if !entry.deleted {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+func (x *Map) GetIndex(bracket Token, index Value) (Value, RuntimeException) {
+	value, ok := x.Lookup(index)
+	if !ok {
+		return nil, unhashableKeyError(bracket, index)
+	}
+	if value == nil {
+		return nil, NewRuntimeError(
+			bracket,
+			fmt.Sprintf("key %s not found in map", index.Repr()),
+		)
+	}
+	return value, nil
+}
+
+func (x *Map) SetIndex(bracket Token, index Value, value Value) RuntimeException {
+	if !x.Insert(index, value) {
+		return unhashableKeyError(bracket, index)
+	}
+	return nil
+}
+
+type mapMethod struct {
+	arity int
+	fn    func(x *Map, name Token, args []Value) (Value, RuntimeException)
+}
+
+var mapMethods = map[string]mapMethod{
+	"len": {0, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		return NewInteger(int64(x.count)), nil
+	}},
+	"keys": {0, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		return NewList(x.Keys()), nil
+	}},
+	"values": {0, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		return NewList(x.Values()), nil
+	}},
+	"iter": {0, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		return x.iterator(false), nil
+	}},
+	"has": {1, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		value, ok := x.Lookup(args[0])
+		if !ok {
+			return nil, unhashableKeyError(name, args[0])
+		}
+		return NewBool(value != nil), nil
+	}},
+	"delete": {1, func(x *Map, name Token, args []Value) (Value, RuntimeException) {
+		deleted, ok := x.Delete(args[0])
+		if !ok {
+			return nil, unhashableKeyError(name, args[0])
+		}
+		return NewBool(deleted), nil
+	}},
+}
+
+func (x *Map) Get(name Token) (Value, RuntimeException) {
+	method, ok := mapMethods[name.lexeme]
+	if !ok {
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("undefined map method '%s'", name.lexeme),
+		)
+	}
+
+	return NewNativeFn(method.arity, name.lexeme, func(args []Value) (Value, RuntimeException) {
+		return method.fn(x, name, args)
+	}), nil
+}
+
+func (x *Map) Set(name Token, value Value) RuntimeException {
+	return NewRuntimeError(name, "cannot set properties on a map")
+}
@@ -0,0 +1,59 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "methods",
+			source: `
+				var m = {"a": 1, 2: "b"};
+				m["c"] = [3];
+				print m;
+				print m.len();
+				print m.has("a");
+				print m.delete("a");
+				print m.delete("a");
+				print m.has("a");
+				print m.keys();
+				print m.values();
+				print {};
+			`,
+			output: "{\"a\": 1, 2: \"b\", \"c\": [3]}\n3\ntrue\ntrue\nfalse\nfalse\n[2, \"c\"]\n[\"b\", [3]]\n{}\n",
+		},
+		{
+			name: "keys",
+			source: `
+				var m = {};
+				m[1] = "int";
+				m[1.0] = "float";
+				m[2 ** 70] = "big";
+				m[nil] = "nil";
+				m[true] = "bool";
+				print m[1];
+				print m[2 ** 70];
+				print m.len();
+				var n = {};
+				n["self"] = n;
+				print n;
+			`,
+			output: "float\nbig\n4\n{\"self\": {...}}\n",
+		},
+		{
+			name: "errors",
+			source: `
+				var m = {"a": 1};
+				try { m["b"]; } catch (e) { print e.message; }
+				try { m[[1]] = 1; } catch (e) { print e.message; }
+				try { m.has({}); } catch (e) { print e.message; }
+				try { m.size(); } catch (e) { print e.message; }
+				try { m.x = 1; } catch (e) { print e.message; }
+			`,
+			output: "key \"b\" not found in map\nlist values cannot be used as map keys\n" +
+				"map values cannot be used as map keys\nundefined map method 'size'\n" +
+				"cannot set properties on a map\n",
+		},
+	})
+}
@@ -0,0 +1,199 @@
+package lox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// module; a namespace holding the top-level definitions of a file
+type Module struct {
+	name    string
+	globals map[string]Value
+	natives map[string]Value
+}
+
+func (x *Module) Type() Type {
+	return TypeModule
+}
+
+func (x *Module) Bool() bool {
+	return true
+}
+
+func (x *Module) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *Module) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *Module) String() string {
+	return fmt.Sprintf("<module '%s'>", x.name)
+}
+
+func (x *Module) Repr() string {
+	return x.String()
+}
+
+func (x *Module) Get(name Token) (Value, RuntimeException) {
+	value, ok := x.globals[name.lexeme]
+	native, isNative := x.natives[name.lexeme]
+	if !ok || (isNative && value == native) {
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("undefined name '%s' in module '%s'", name.lexeme, x.name),
+		)
+	}
+	if value == nil {
+		return nil, NewRuntimeError(
+			name,
+			fmt.Sprintf("using uninitialized variable '%s'", name.lexeme),
+		)
+	}
+	return value, nil
+}
+
+func (x *Module) Set(name Token, value Value) RuntimeException {
+	return NewRuntimeError(name, "cannot set properties on a module")
+}
+
+// Runs the resolved statements of a module with its own globals, which
+// start out holding the natives. Paths imported by the module are relative
+// to dir.
+type moduleRunner func(stmts []Stmt, globals map[string]Value, dir string) RuntimeException
+
+// Loads each module once per program, no matter how many times (or from
+// where) it is imported. Shared between the main script and every module it
+// imports, directly or indirectly.
+type moduleLoader struct {
+	natives map[string]Value
+	modules map[string]*Module
+	loading []string
+}
+
+func newModuleLoader() *moduleLoader {
+	return &moduleLoader{
+		natives: map[string]Value{},
+		modules: map[string]*Module{},
+		loading: []string{},
+	}
+}
+
+func (l *moduleLoader) globals() map[string]Value {
+	globals := make(map[string]Value, len(l.natives))
+	for name, value := range l.natives {
+		globals[name] = value
+	}
+	return globals
+}
+
+// Marks the main script as being loaded, so that modules importing it are
+// reported as a cycle rather than running it a second time.
+func (l *moduleLoader) setMain(path string) {
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		l.loading = append(l.loading[:0], abs)
+	}
+}
+
+func (l *moduleLoader) load(token Token, dir string, path string, run moduleRunner) (*Module, RuntimeException) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, NewRuntimeError(
+			token,
+			fmt.Sprintf("invalid module path '%s'", path),
+		)
+	}
+
+	module, ok := l.modules[abs]
+	if ok {
+		return module, nil
+	}
+
+	for i, loading := range l.loading {
+		if loading == abs {
+			cycle := []string{}
+			for _, p := range append(l.loading[i:], abs) {
+				cycle = append(cycle, filepath.Base(p))
+			}
+			return nil, NewRuntimeError(
+				token,
+				fmt.Sprintf("import cycle: %s", strings.Join(cycle, " -> ")),
+			)
+		}
+	}
+
+	content, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, NewRuntimeError(
+			token,
+			fmt.Sprintf("cannot read module '%s'", path),
+		)
+	}
+
+	stmts, messages := parseModule(path, string(content))
+	if len(messages) > 0 {
+		return nil, NewRuntimeError(
+			token,
+			fmt.Sprintf("failed to load module '%s':\n%s", path, strings.Join(messages, "\n")),
+		)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs))
+	globals := l.globals()
+	l.loading = append(l.loading, abs)
+	rerr := run(stmts, globals, filepath.Dir(abs))
+	l.loading = l.loading[:len(l.loading)-1]
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	module = &Module{
+		name:    name,
+		globals: globals,
+		natives: l.natives,
+	}
+	l.modules[abs] = module
+	return module, nil
+}
+
+// Returns the messages of any errors found while scanning, parsing or
+// resolving a module.
+func parseModule(name string, source string) ([]Stmt, []string) {
+	scanner := NewScanner(name, source)
+	tokens, serrs := scanner.ScanTokens()
+	if len(serrs) > 0 {
+		messages := make([]string, len(serrs))
+		for i, err := range serrs {
+			messages[i] = err.Error()
+		}
+		return nil, messages
+	}
+
+	parser := NewParser(tokens)
+	stmts, perrs := parser.ParseStatements()
+	if len(perrs) > 0 {
+		messages := make([]string, len(perrs))
+		for i, err := range perrs {
+			messages[i] = err.Error()
+		}
+		return nil, messages
+	}
+
+	resolver := NewResolver()
+	rerrs := resolver.ResolveStatements(stmts)
+	if len(rerrs) > 0 {
+		messages := make([]string, len(rerrs))
+		for i, err := range rerrs {
+			messages[i] = err.String()
+		}
+		return nil, messages
+	}
+	return stmts, nil
+}
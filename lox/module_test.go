@@ -0,0 +1,138 @@
+package lox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes files, keyed by their path relative to a fresh temporary directory,
+// and returns that directory
+func writeModuleFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunFileImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		output string
+		err    string
+	}{
+		{
+			name: "import and from",
+			files: map[string]string{
+				"main.lox":     `import "lib/util.lox" as util; from "lib/util.lox" import twice; print util.twice(2) + twice(3);`,
+				"lib/util.lox": `print "loading"; fun twice(x) { return x * 2; }`,
+			},
+			output: "loading\n10\n",
+		},
+		{
+			name: "loaded once",
+			files: map[string]string{
+				"main.lox":   `import "a.lox" as a; import "b.lox" as b; a.counter.n = 5; print b.read();`,
+				"a.lox":      `import "shared.lox" as s; var counter = s.counter;`,
+				"b.lox":      `import "shared.lox" as s; fun read() { return s.counter.n; }`,
+				"shared.lox": `print "shared"; class Counter {} var counter = Counter(); counter.n = 0;`,
+			},
+			output: "shared\n5\n",
+		},
+		{
+			name: "relative to the importing file",
+			files: map[string]string{
+				"main.lox":  `from "lib/a.lox" import name; print name;`,
+				"lib/a.lox": `from "b.lox" import name;`,
+				"lib/b.lox": `var name = "b";`,
+			},
+			output: "b\n",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.lox": `import "a.lox" as a;`,
+				"a.lox":    `import "b.lox" as b;`,
+				"b.lox":    `import "a.lox" as a;`,
+			},
+			err: "import cycle: a.lox -> b.lox -> a.lox",
+		},
+		{
+			name: "importing the main script",
+			files: map[string]string{
+				"main.lox": `import "a.lox" as a;`,
+				"a.lox":    `import "main.lox" as m;`,
+			},
+			err: "import cycle: main.lox -> a.lox -> main.lox",
+		},
+		{
+			name: "undefined name",
+			files: map[string]string{
+				"main.lox": `from "a.lox" import missing;`,
+				"a.lox":    `var present = 1;`,
+			},
+			err: "undefined name 'missing' in module 'a'",
+		},
+		{
+			name: "natives are not exported",
+			files: map[string]string{
+				"main.lox": `import "a.lox" as a; print a.readLine;`,
+				"a.lox":    `var present = 1;`,
+			},
+			err: "undefined name 'readLine' in module 'a'",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"main.lox": `import "missing.lox" as m;`,
+			},
+			err: "cannot read module",
+		},
+		{
+			name: "syntax error in module",
+			files: map[string]string{
+				"main.lox": `import "a.lox" as a;`,
+				"a.lox":    `var = 1;`,
+			},
+			err: "a.lox:1:5: syntax error at =: expected variable name",
+		},
+		{
+			name: "modules are read-only",
+			files: map[string]string{
+				"main.lox": `import "a.lox" as a; a.present = 2;`,
+				"a.lox":    `var present = 1;`,
+			},
+			err: "cannot set properties on a module",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModuleFiles(t, test.files)
+			for _, engine := range engines {
+				var stdout bytes.Buffer
+				in := engine.new()
+				in.SetStdout(&stdout)
+				err := in.RunFile(filepath.Join(dir, "main.lox"))
+				if test.err == "" && err != nil {
+					t.Errorf("%s: %v", engine.name, err)
+				} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Errorf("%s: got error %v, want one containing %q", engine.name, err, test.err)
+				}
+				if got := stdout.String(); got != test.output {
+					t.Errorf("%s: got output %q, want %q", engine.name, got, test.output)
+				}
+			}
+		})
+	}
+}
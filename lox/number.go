@@ -0,0 +1,537 @@
+package lox
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// integer that fits in an int64
+type Integer struct{ value int64 }
+
+func NewInteger(value int64) Integer {
+	return Integer{value: value}
+}
+
+func (x Integer) Type() Type {
+	return TypeInteger
+}
+
+func (x Integer) Bool() bool {
+	return true
+}
+
+func (x Integer) Equal(other Value) bool {
+	order, ok := compareNumbers(x, other)
+	return ok && order == 0
+}
+
+func (x Integer) Hash() (uint64, bool) {
+	// Integers that can be represented exactly as floats are equal to
+	// those floats, so they need to hash the same
+	if f, ok := exactFloat(x); ok {
+		return NewNumber(f).Hash()
+	}
+	return hashBigInteger(big.NewInt(x.value)), true
+}
+
+func (x Integer) String() string {
+	return strconv.FormatInt(x.value, 10)
+}
+
+func (x Integer) Repr() string {
+	return x.String()
+}
+
+func (x Integer) Int64() int64 {
+	return x.value
+}
+
+func (x Integer) Float() float64 {
+	return float64(x.value)
+}
+
+// integer that does not fit in an int64. Arithmetic on integers switches
+// between the two representations as needed, so a BigInteger is never
+// within the range of an int64.
+type BigInteger struct{ value *big.Int }
+
+// Returns an Integer or BigInteger with the given value. The result does
+// not keep a reference to value.
+func NewBigInteger(value *big.Int) Value {
+	return bigInteger(new(big.Int).Set(value))
+}
+
+// Like NewBigInteger, but takes ownership of value
+func bigInteger(value *big.Int) Value {
+	if value.IsInt64() {
+		return NewInteger(value.Int64())
+	}
+	return BigInteger{value: value}
+}
+
+func (x BigInteger) Type() Type {
+	return TypeInteger
+}
+
+func (x BigInteger) Bool() bool {
+	return true
+}
+
+func (x BigInteger) Equal(other Value) bool {
+	order, ok := compareNumbers(x, other)
+	return ok && order == 0
+}
+
+func (x BigInteger) Hash() (uint64, bool) {
+	return hashBigInteger(x.value), true
+}
+
+func (x BigInteger) String() string {
+	return x.value.String()
+}
+
+func (x BigInteger) Repr() string {
+	return x.String()
+}
+
+// Returns the value as a new big.Int
+func (x BigInteger) Big() *big.Int {
+	return new(big.Int).Set(x.value)
+}
+
+// Returns the float closest to the value
+func (x BigInteger) Float() float64 {
+	f, _ := new(big.Float).SetInt(x.value).Float64()
+	return f
+}
+
+func hashBigInteger(x *big.Int) uint64 {
+	f, accuracy := new(big.Float).SetInt(x).Float64()
+	if accuracy == big.Exact {
+		h, _ := NewNumber(f).Hash()
+		return h
+	}
+	return hashString(x.String())
+}
+
+// Returns the value of an Integer or BigInteger as a big.Int, which must
+// not be modified, or false if it is not an integer
+func toBig(x Value) (*big.Int, bool) {
+	switch x := x.(type) {
+	case Integer:
+		return big.NewInt(x.value), true
+	case BigInteger:
+		return x.value, true
+	}
+	return nil, false
+}
+
+func isInteger(x Value) bool {
+	switch x.(type) {
+	case Integer, BigInteger:
+		return true
+	}
+	return false
+}
+
+// Returns an integer, or a float with no fractional part converted to an
+// integer, or false if the value is neither
+func wholeInteger(x Value) (Value, bool) {
+	switch x := x.(type) {
+	case Integer, BigInteger:
+		return x, true
+	case Number:
+		if math.IsInf(x.value, 0) || x.value != math.Trunc(x.value) {
+			return nil, false
+		}
+		if x.value >= -1<<63 && x.value < 1<<63 {
+			return NewInteger(int64(x.value)), true
+		}
+		b, _ := big.NewFloat(x.value).Int(nil)
+		return bigInteger(b), true
+	}
+	return nil, false
+}
+
+func isNumber(x Value) bool {
+	switch x.(type) {
+	case Integer, BigInteger, Number:
+		return true
+	}
+	return false
+}
+
+// Returns the float value of an integer or float
+func toFloat(x Value) float64 {
+	switch x := x.(type) {
+	case Integer:
+		return x.Float()
+	case BigInteger:
+		return x.Float()
+	}
+	return x.(Number).value
+}
+
+func integerSign(x Value) int {
+	b, _ := toBig(x)
+	return b.Sign()
+}
+
+// Compares two numbers exactly, even if one is an integer too big to be
+// represented as a float. Returns false if either is not a number, or if
+// they are unordered because one is NaN.
+func compareNumbers(left Value, right Value) (int, bool) {
+	if !isNumber(left) || !isNumber(right) {
+		return 0, false
+	}
+
+	li, lsmall := left.(Integer)
+	ri, rsmall := right.(Integer)
+	if lsmall && rsmall {
+		switch {
+		case li.value < ri.value:
+			return -1, true
+		case li.value > ri.value:
+			return 1, true
+		}
+		return 0, true
+	}
+	if isInteger(left) && isInteger(right) {
+		lb, _ := toBig(left)
+		rb, _ := toBig(right)
+		return lb.Cmp(rb), true
+	}
+
+	if math.IsNaN(toFloat(left)) || math.IsNaN(toFloat(right)) {
+		return 0, false
+	}
+	lf, lexact := exactFloat(left)
+	rf, rexact := exactFloat(right)
+	if lexact && rexact {
+		switch {
+		case lf < rf:
+			return -1, true
+		case lf > rf:
+			return 1, true
+		}
+		return 0, true
+	}
+	return bigFloat(left).Cmp(bigFloat(right)), true
+}
+
+// Returns a number as a float, or false if it is an integer that might not
+// be exactly representable as one
+func exactFloat(x Value) (float64, bool) {
+	switch x := x.(type) {
+	case Integer:
+		if x.value >= -1<<53 && x.value <= 1<<53 {
+			return float64(x.value), true
+		}
+		return 0, false
+	case BigInteger:
+		return 0, false
+	}
+	return x.(Number).value, true
+}
+
+func bigFloat(x Value) *big.Float {
+	if b, ok := toBig(x); ok {
+		return new(big.Float).SetInt(b)
+	}
+	return big.NewFloat(x.(Number).value)
+}
+
+// Integers produced by ** and << may be at most this many bits long, so
+// that a single operation can't take up all of the memory or time of the
+// program running it
+const maxIntegerBits = 1 << 22
+
+// Applies an arithmetic, comparison or bitwise operator to two values.
+// Operations on two integers give an integer, except for / and ** with a
+// negative exponent, which give floats like any operation involving a
+// float. Returns an error message if the operands are not valid for the
+// operator.
+func arithmetic(ty TokenType, lexeme string, left Value, right Value) (Value, string) {
+	switch ty {
+	case TokenTypePlus:
+		if !isNumber(left) || !isNumber(right) {
+			if left.Type() == TypeString || right.Type() == TypeString {
+				return NewString(left.String() + right.String()), ""
+			}
+			return nil, fmt.Sprintf("%s operands must be numbers or strings", lexeme)
+		}
+	case TokenTypeAmpersand,
+		TokenTypePipe,
+		TokenTypeCaret,
+		TokenTypeLessLess,
+		TokenTypeGreaterGreater:
+
+		l, lwhole := wholeInteger(left)
+		r, rwhole := wholeInteger(right)
+		if !lwhole || !rwhole {
+			return nil, fmt.Sprintf("%s operands must be whole numbers", lexeme)
+		}
+		left, right = l, r
+	default:
+		if !isNumber(left) || !isNumber(right) {
+			return nil, fmt.Sprintf("%s operands must be numbers", lexeme)
+		}
+	}
+
+	switch ty {
+	case TokenTypeGreater,
+		TokenTypeGreaterEqual,
+		TokenTypeLess,
+		TokenTypeLessEqual:
+
+		order, ok := compareNumbers(left, right)
+		if !ok {
+			return NewBool(false), ""
+		}
+		switch ty {
+		case TokenTypeGreater:
+			return NewBool(order > 0), ""
+		case TokenTypeGreaterEqual:
+			return NewBool(order >= 0), ""
+		case TokenTypeLess:
+			return NewBool(order < 0), ""
+		default:
+			return NewBool(order <= 0), ""
+		}
+	}
+
+	if isInteger(left) && isInteger(right) {
+		return integerArithmetic(ty, left, right)
+	}
+	return floatArithmetic(ty, toFloat(left), toFloat(right))
+}
+
+func floatArithmetic(ty TokenType, l float64, r float64) (Value, string) {
+	switch ty {
+	case TokenTypePlus:
+		return NewNumber(l + r), ""
+	case TokenTypeMinus:
+		return NewNumber(l - r), ""
+	case TokenTypeStar:
+		return NewNumber(l * r), ""
+	case TokenTypeStarStar:
+		return NewNumber(math.Pow(l, r)), ""
+	case TokenTypeSlash, TokenTypePercent, TokenTypeTildeSlash:
+		if r == 0 {
+			return nil, "division by zero"
+		}
+		switch ty {
+		case TokenTypeSlash:
+			return NewNumber(l / r), ""
+		case TokenTypePercent:
+			return NewNumber(floorMod(l, r)), ""
+		default:
+			return NewNumber(math.Floor(l / r)), ""
+		}
+	default:
+		panic(fmt.Sprintf("unknown arithmetic operator: %v", ty))
+	}
+}
+
+func integerArithmetic(ty TokenType, left Value, right Value) (Value, string) {
+	switch ty {
+	case TokenTypeSlash:
+		if integerSign(right) == 0 {
+			return nil, "division by zero"
+		}
+		return NewNumber(toFloat(left) / toFloat(right)), ""
+	case TokenTypePercent, TokenTypeTildeSlash:
+		if integerSign(right) == 0 {
+			return nil, "division by zero"
+		}
+	case TokenTypeStarStar:
+		if integerSign(right) < 0 {
+			return NewNumber(math.Pow(toFloat(left), toFloat(right))), ""
+		}
+		if powerBits(left, right) > maxIntegerBits {
+			return nil, "result too large"
+		}
+	case TokenTypeLessLess, TokenTypeGreaterGreater:
+		if integerSign(right) < 0 {
+			return nil, "negative shift count"
+		}
+		count, ok := right.(Integer)
+		if !ok {
+			return nil, "shift count too large"
+		}
+		if ty == TokenTypeLessLess && integerSign(left) != 0 {
+			a, _ := toBig(left)
+			if count.value > int64(maxIntegerBits-a.BitLen()) {
+				return nil, "result too large"
+			}
+		}
+	}
+
+	l, lsmall := left.(Integer)
+	r, rsmall := right.(Integer)
+	if lsmall && rsmall {
+		result, ok := smallIntegerArithmetic(ty, l.value, r.value)
+		if ok {
+			return result, ""
+		}
+	}
+	a, _ := toBig(left)
+	b, _ := toBig(right)
+	return bigIntegerArithmetic(ty, a, b), ""
+}
+
+// Returns roughly how many bits a ** b takes, where b is not negative
+func powerBits(a Value, b Value) float64 {
+	base, _ := toBig(a)
+	if base.CmpAbs(big.NewInt(1)) <= 0 {
+		return 1
+	}
+	exponent, _ := toBig(b)
+	bits := float64(base.BitLen())
+	if base.IsInt64() {
+		bits = math.Log2(math.Abs(float64(base.Int64())))
+	}
+	e, _ := new(big.Float).SetInt(exponent).Float64()
+	return bits * e
+}
+
+// Returns false if the result does not fit in an int64
+func smallIntegerArithmetic(ty TokenType, a int64, b int64) (Value, bool) {
+	switch ty {
+	case TokenTypePlus:
+		sum := a + b
+		if (b > 0 && sum < a) || (b < 0 && sum > a) {
+			return nil, false
+		}
+		return NewInteger(sum), true
+	case TokenTypeMinus:
+		difference := a - b
+		if (b > 0 && difference > a) || (b < 0 && difference < a) {
+			return nil, false
+		}
+		return NewInteger(difference), true
+	case TokenTypeStar:
+		if a == 0 || b == 0 {
+			return NewInteger(0), true
+		}
+		product := a * b
+		if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return nil, false
+		}
+		return NewInteger(product), true
+	case TokenTypePercent:
+		m := a % b
+		if m != 0 && (m < 0) != (b < 0) {
+			m += b
+		}
+		return NewInteger(m), true
+	case TokenTypeTildeSlash:
+		if a == math.MinInt64 && b == -1 {
+			return nil, false
+		}
+		q := a / b
+		if a%b != 0 && (a < 0) != (b < 0) {
+			q--
+		}
+		return NewInteger(q), true
+	case TokenTypeAmpersand:
+		return NewInteger(a & b), true
+	case TokenTypePipe:
+		return NewInteger(a | b), true
+	case TokenTypeCaret:
+		return NewInteger(a ^ b), true
+	case TokenTypeLessLess:
+		if b >= 63 || (a<<uint64(b))>>uint64(b) != a {
+			return nil, false
+		}
+		return NewInteger(a << uint64(b)), true
+	case TokenTypeGreaterGreater:
+		return NewInteger(a >> uint64(b)), true
+	default:
+		return nil, false
+	}
+}
+
+func bigIntegerArithmetic(ty TokenType, a *big.Int, b *big.Int) Value {
+	result := new(big.Int)
+	switch ty {
+	case TokenTypePlus:
+		result.Add(a, b)
+	case TokenTypeMinus:
+		result.Sub(a, b)
+	case TokenTypeStar:
+		result.Mul(a, b)
+	case TokenTypeStarStar:
+		result.Exp(a, b, nil)
+	case TokenTypePercent:
+		result.Rem(a, b)
+		if result.Sign() != 0 && (result.Sign() < 0) != (b.Sign() < 0) {
+			result.Add(result, b)
+		}
+	case TokenTypeTildeSlash:
+		m := new(big.Int)
+		result.QuoRem(a, b, m)
+		if m.Sign() != 0 && (m.Sign() < 0) != (b.Sign() < 0) {
+			result.Sub(result, big.NewInt(1))
+		}
+	case TokenTypeAmpersand:
+		result.And(a, b)
+	case TokenTypePipe:
+		result.Or(a, b)
+	case TokenTypeCaret:
+		result.Xor(a, b)
+	case TokenTypeLessLess:
+		result.Lsh(a, uint(b.Int64()))
+	case TokenTypeGreaterGreater:
+		result.Rsh(a, uint(b.Int64()))
+	default:
+		panic(fmt.Sprintf("unknown arithmetic operator: %v", ty))
+	}
+	return bigInteger(result)
+}
+
+// Applies unary - or ~ to a value. Returns an error message if the operand
+// is not valid for the operator.
+func unaryArithmetic(ty TokenType, lexeme string, right Value) (Value, string) {
+	switch ty {
+	case TokenTypeMinus:
+		switch x := right.(type) {
+		case Integer:
+			if x.value != math.MinInt64 {
+				return NewInteger(-x.value), ""
+			}
+			return bigInteger(new(big.Int).Neg(big.NewInt(x.value))), ""
+		case BigInteger:
+			return bigInteger(new(big.Int).Neg(x.value)), ""
+		case Number:
+			return NewNumber(-x.value), ""
+		}
+		return nil, fmt.Sprintf("unary %s operand must be a number", lexeme)
+	case TokenTypeTilde:
+		whole, ok := wholeInteger(right)
+		if !ok {
+			return nil, fmt.Sprintf("unary %s operand must be a whole number", lexeme)
+		}
+		switch x := whole.(type) {
+		case Integer:
+			return NewInteger(^x.value), ""
+		case BigInteger:
+			return bigInteger(new(big.Int).Not(x.value)), ""
+		}
+		panic(fmt.Sprintf("not an integer: %v", whole))
+	default:
+		panic(fmt.Sprintf("unknown unary operator: %v", ty))
+	}
+}
+
+// Returns the remainder of x / y rounded towards negative infinity, so
+// that the result has the same sign as y
+func floorMod(x float64, y float64) float64 {
+	m := math.Mod(x, y)
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
@@ -0,0 +1,156 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestOperators(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "modulo and floor division",
+			source: `
+				print 7 % 3;
+				print -7 % 3;
+				print -7 % -3;
+				print 7.5 % 2;
+				print -7.5 % 2;
+				print 7 ~/ 2;
+				print -7 ~/ 2;
+				print 7 ~/ -2;
+				print 7.5 ~/ 2;
+				print 2 ** 64 % 10;
+				print -(2 ** 64) ~/ 3;
+			`,
+			output: "1\n2\n-1\n1.5\n0.5\n3\n-4\n-4\n3.0\n6\n-6148914691236517206\n",
+		},
+		{
+			name: "exponentiation",
+			source: `
+				print 2 ** 10;
+				print 2 ** 3 ** 2;
+				print -2 ** 2;
+				print 2 ** -1;
+				print 2 ** 0.5;
+				print 0 ** 0;
+			`,
+			output: "1024\n512\n-4\n0.5\n1.4142135623730951\n1\n",
+		},
+		{
+			name: "bitwise",
+			source: `
+				print 6 & 3;
+				print 6 | 3;
+				print 6 ^ 3;
+				print ~5;
+				print 1 << 3;
+				print -16 >> 2;
+				print -1 >> 1;
+				print 1 | 2 & 3;
+				print 1 + 1 << 2;
+				print 5 & 3 == 1;
+				print (6 / 2) & 1;
+			`,
+			output: "2\n7\n5\n-6\n8\n-4\n-1\n3\n8\ntrue\n1\n",
+		},
+		{
+			name: "compound assignment",
+			source: `
+				var x = 17;
+				x %= 5;
+				print x;
+			`,
+			output: "2\n",
+		},
+		{
+			name: "errors",
+			source: `
+				try { 7 % 0; } catch (e) { print e.message; }
+				try { 7 ~/ 0; } catch (e) { print e.message; }
+				try { 7.0 ~/ 0; } catch (e) { print e.message; }
+				try { 1 << -1; } catch (e) { print e.message; }
+				try { ~1.5; } catch (e) { print e.message; }
+				try { "a" % 2; } catch (e) { print e.message; }
+			`,
+			output: "division by zero\ndivision by zero\ndivision by zero\nnegative shift count\n" +
+				"unary ~ operand must be a whole number\n% operands must be numbers\n",
+		},
+		{
+			name:   "bitwise on a fraction",
+			source: `print 1.5 | 1;`,
+			err:    "| operands must be whole numbers",
+		},
+		{
+			name: "comments",
+			source: `
+				class Foo // a class
+				{
+					get() { return 1; } // a method
+				}
+				var total = Foo().get()   // first part
+					+ 2;                  // second part
+				print total /* block */ ~/ 2;
+			`,
+			output: "1\n",
+		},
+	})
+}
+
+func TestIntegers(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "overflow into big integers",
+			source: `
+				print 9223372036854775807 + 1;
+				print 9223372036854775807 + 1 - 1;
+				print -9223372036854775808 - 1;
+				print -(-9223372036854775807 - 1);
+				print 2 ** 100;
+				print 2 ** 63 * 2 ** 63;
+				print 1 << 70;
+			`,
+			output: "9223372036854775808\n9223372036854775807\n-9223372036854775809\n" +
+				"9223372036854775808\n1267650600228229401496703205376\n" +
+				"85070591730234615865843651857942052864\n1180591620717411303424\n",
+		},
+		{
+			name: "mixing integers and floats",
+			source: `
+				print 7 / 2;
+				print 10 ** 20 / 10 ** 19;
+				print 3 * 1.5;
+				print 1 == 1.0;
+				print 2 ** 64 == 2.0 ** 64;
+				print 2 ** 53 + 1 == 2.0 ** 53;
+				print 2 ** 64 > 1.5;
+				print 2 ** 64 < 2 ** 65;
+				print 1.0;
+				print 1e20;
+			`,
+			output: "3.5\n10.0\n4.5\ntrue\ntrue\nfalse\ntrue\ntrue\n1.0\n100000000000000000000.0\n",
+		},
+		{
+			name: "size limits",
+			source: `
+				print (1 << 4000000) % 1000;
+				print 1 ** 10 ** 30;
+				try { print 1 << 2 ** 40; } catch (e) { print e.message; }
+				try { print 10 ** 100000000000; } catch (e) { print e.message; }
+			`,
+			output: "376\n1\nresult too large\nresult too large\n",
+		},
+		{
+			name: "whole float indices",
+			source: `
+				var xs = [1, 2, 3];
+				print xs[4 / 2];
+				xs[1.0] = 5;
+				print xs.slice(0.0, 6 / 3);
+				try { print xs[0.5]; } catch (e) { print e.message; }
+				try { print xs[3.0]; } catch (e) { print e.message; }
+				try { print xs[2 ** 64]; } catch (e) { print e.message; }
+			`,
+			output: "3\n[1, 5]\nlist index must be a whole number\nlist index 3.0 out of range\n" +
+				"list index 18446744073709551616 out of range\n",
+		},
+	})
+}
@@ -1,18 +1,24 @@
 package lox
 
+import (
+	"strings"
+)
+
 type Parser struct {
-	tokens    []Token
-	current   int
-	errors    []*SyntaxError
-	loopDepth int
+	tokens  []Token
+	current int
+	errors  []*SyntaxError
+	loops   []*Token // labels of the enclosing loops, nil if unlabeled
+	labels  map[string]bool
 }
 
 func NewParser(tokens []Token) *Parser {
 	return &Parser{
-		tokens:    tokens,
-		current:   0,
-		errors:    []*SyntaxError{},
-		loopDepth: 0,
+		tokens:  tokens,
+		current: 0,
+		errors:  []*SyntaxError{},
+		loops:   []*Token{},
+		labels:  map[string]bool{},
 	}
 }
 
@@ -96,6 +102,7 @@ func (p *Parser) classDeclaration() Stmt {
 		superclass = &VariableExpr{
 			name:     p.previous(),
 			distance: new(int),
+			slot:     new(int),
 		}
 	}
 
@@ -119,25 +126,39 @@ func (p *Parser) classDeclaration() Stmt {
 		superclass:   superclass,
 		methods:      methods,
 		classMethods: classMethods,
+		slot:         new(int),
 	}
 }
 
-func (p *Parser) parameterList() []Token {
+// Returns the parameters, their default values (nil for parameters without
+// one), and whether the last parameter is a rest parameter
+func (p *Parser) parameterList() ([]Token, []Expr, bool) {
 	parameters := []Token{}
+	defaults := []Expr{}
+	variadic := false
 	if !p.check(TokenTypeRightParen) {
 		for {
 			if len(parameters) >= 255 {
 				p.addError(p.peek(), "can't have more than 255 parameters")
 			}
+			variadic = p.match(TokenTypeDotDotDot)
 			name := p.consume(TokenTypeIdentifier, "expected parameter name")
 			parameters = append(parameters, name)
+			if !variadic && p.match(TokenTypeEqual) {
+				defaults = append(defaults, p.assignment())
+			} else {
+				defaults = append(defaults, nil)
+			}
 			if !p.match(TokenTypeComma) {
 				break
 			}
+			if variadic {
+				p.addError(name, "rest parameter must be the last parameter")
+			}
 		}
 	}
 	p.consume(TokenTypeRightParen, "expected ')' after parameters")
-	return parameters
+	return parameters, defaults, variadic
 }
 
 func (p *Parser) methodStatement() Stmt {
@@ -145,23 +166,31 @@ func (p *Parser) methodStatement() Stmt {
 
 	var isProperty bool
 	var parameters []Token
+	var defaults []Expr
+	var variadic bool
 	if p.match(TokenTypeLeftParen) {
 		isProperty = false
-		parameters = p.parameterList()
+		parameters, defaults, variadic = p.parameterList()
 	} else {
 		isProperty = true
 		parameters = nil
+		defaults = nil
+		variadic = false
 	}
 
 	p.consume(TokenTypeLeftBrace, "expected '{' before method body")
-	body := p.block().(BlockStmt)
+	body := p.functionBody()
 	return MethodStmt{
 		FnStmt: FnStmt{
 			name: name,
 			function: FnExpr{
 				parameters: parameters,
+				defaults:   defaults,
+				variadic:   variadic,
 				body:       body.statements,
+				size:       new(int),
 			},
+			slot: new(int),
 		},
 		isProperty: isProperty,
 	}
@@ -173,21 +202,66 @@ func (p *Parser) functionStatement() Stmt {
 	return FnStmt{
 		name:     name,
 		function: function,
+		slot:     new(int),
 	}
 }
 
 func (p *Parser) functionExpression() Expr {
 	p.consume(TokenTypeLeftParen, "expected '(' after 'fun'")
-	parameters := p.parameterList()
+	parameters, defaults, variadic := p.parameterList()
 
 	p.consume(TokenTypeLeftBrace, "expected '{' before function body")
-	body := p.block().(BlockStmt)
+	body := p.functionBody()
 	return FnExpr{
 		parameters: parameters,
+		defaults:   defaults,
+		variadic:   variadic,
 		body:       body.statements,
+		size:       new(int),
 	}
 }
 
+// Loops outside a function can't be broken out of from inside it
+func (p *Parser) functionBody() BlockStmt {
+	loops := p.loops
+	p.loops = []*Token{}
+	defer func() {
+		p.loops = loops
+	}()
+	return p.block().(BlockStmt)
+}
+
+// Parses the rest of a for-in loop, starting from its first variable
+func (p *Parser) forInStatement(label *Token) Stmt {
+	variables := []Token{p.advance()}
+	if p.match(TokenTypeComma) {
+		variables = append(variables, p.consume(TokenTypeIdentifier, "expected variable name after ','"))
+	}
+	keyword := p.consumeContextual("in", "expected 'in' after loop variables")
+	iterable := p.expression()
+	p.consume(TokenTypeRightParen, "expected ')' after iterable")
+
+	body := p.loopBody(label)
+
+	return ForInStmt{
+		label:     label,
+		variables: variables,
+		keyword:   keyword,
+		iterable:  iterable,
+		body:      body,
+		size:      new(int),
+	}
+}
+
+// Parses the body of a loop with the given label, which may be nil
+func (p *Parser) loopBody(label *Token) Stmt {
+	p.loops = append(p.loops, label)
+	defer func() {
+		p.loops = p.loops[:len(p.loops)-1]
+	}()
+	return p.statement()
+}
+
 func (p *Parser) varDeclaration() Stmt {
 	name := p.consume(TokenTypeIdentifier, "expected variable name")
 
@@ -201,12 +275,16 @@ func (p *Parser) varDeclaration() Stmt {
 	return VarStmt{
 		name:        name,
 		initializer: initializer,
+		slot:        new(int),
 	}
 }
 
 func (p *Parser) statement() Stmt {
+	if p.check(TokenTypeIdentifier) && p.checkNext(TokenTypeColon) {
+		return p.labeledStatement()
+	}
 	if p.match(TokenTypeFor) {
-		return p.forStatement()
+		return p.forStatement(nil)
 	}
 	if p.match(TokenTypeIf) {
 		return p.ifStatement()
@@ -218,20 +296,61 @@ func (p *Parser) statement() Stmt {
 		return p.returnStatement()
 	}
 	if p.match(TokenTypeWhile) {
-		return p.whileStatement()
+		return p.whileStatement(nil)
 	}
 	if p.match(TokenTypeBreak) {
 		return p.breakStatement()
 	}
+	if p.match(TokenTypeContinue) {
+		return p.continueStatement()
+	}
+	if p.match(TokenTypeTry) {
+		return p.tryStatement()
+	}
+	if p.match(TokenTypeThrow) {
+		return p.throwStatement()
+	}
+	if p.match(TokenTypeImport) {
+		return p.importStatement()
+	}
+	if p.checkContextual("from") && p.checkNext(TokenTypeString) {
+		p.advance()
+		return p.fromImportStatement()
+	}
 	if p.match(TokenTypeLeftBrace) {
 		return p.block()
 	}
 	return p.expressionStatement()
 }
 
-func (p *Parser) forStatement() Stmt {
+func (p *Parser) labeledStatement() Stmt {
+	label := p.advance()
+	p.advance()
+
+	for _, outer := range p.loops {
+		if outer != nil && outer.lexeme == label.lexeme {
+			p.addError(label, "label is already used by an enclosing loop")
+		}
+	}
+	p.labels[label.lexeme] = true
+
+	if p.match(TokenTypeFor) {
+		return p.forStatement(&label)
+	}
+	if p.match(TokenTypeWhile) {
+		return p.whileStatement(&label)
+	}
+	p.addError(p.peek(), "expected loop after label")
+	panic(unwindToken)
+}
+
+func (p *Parser) forStatement(label *Token) Stmt {
 	p.consume(TokenTypeLeftParen, "expected '(' after 'if'")
 
+	if p.check(TokenTypeIdentifier) && (p.checkNextContextual("in") || p.checkNext(TokenTypeComma)) {
+		return p.forInStatement(label)
+	}
+
 	var initializer *Stmt
 	if p.match(TokenTypeSemicolon) {
 		initializer = nil
@@ -257,27 +376,12 @@ func (p *Parser) forStatement() Stmt {
 	}
 	p.consume(TokenTypeRightParen, "expected ')' after for clauses")
 
-	body := func() Stmt {
-		p.loopDepth++
-		defer func() {
-			p.loopDepth--
-		}()
-		return p.statement()
-	}()
-
-	if increment != nil {
-		body = BlockStmt{
-			statements: []Stmt{
-				body,
-				ExprStmt{expression: *increment},
-			},
-		}
-	}
+	body := p.loopBody(label)
 
 	if condition == nil {
-		body = WhileStmt{condition: LiteralExpr{value: true}, body: body}
+		body = ForStmt{label: label, condition: LiteralExpr{value: true}, increment: increment, body: body}
 	} else {
-		body = WhileStmt{condition: *condition, body: body}
+		body = ForStmt{label: label, condition: *condition, increment: increment, body: body}
 	}
 
 	if initializer != nil {
@@ -286,6 +390,7 @@ func (p *Parser) forStatement() Stmt {
 				*initializer,
 				body,
 			},
+			size: new(int),
 		}
 	}
 
@@ -311,20 +416,15 @@ func (p *Parser) ifStatement() Stmt {
 	}
 }
 
-func (p *Parser) whileStatement() Stmt {
+func (p *Parser) whileStatement(label *Token) Stmt {
 	p.consume(TokenTypeLeftParen, "expected '(' after 'while'")
 	condition := p.expression()
 	p.consume(TokenTypeRightParen, "expected ')' after while condition")
 
-	body := func() Stmt {
-		p.loopDepth++
-		defer func() {
-			p.loopDepth--
-		}()
-		return p.statement()
-	}()
+	body := p.loopBody(label)
 
 	return WhileStmt{
+		label:     label,
 		condition: condition,
 		body:      body,
 	}
@@ -345,11 +445,128 @@ func (p *Parser) returnStatement() Stmt {
 }
 
 func (p *Parser) breakStatement() Stmt {
-	if p.loopDepth == 0 {
-		p.addError(p.previous(), "break can only be used inside a loop")
+	keyword := p.previous()
+	if len(p.loops) == 0 {
+		p.addError(keyword, "break can only be used inside a loop")
 	}
+	label := p.jumpLabel()
 	p.consume(TokenTypeSemicolon, "expected ';' after 'break'")
-	return BreakStmt{}
+	return BreakStmt{label: label}
+}
+
+func (p *Parser) continueStatement() Stmt {
+	keyword := p.previous()
+	if len(p.loops) == 0 {
+		p.addError(keyword, "continue can only be used inside a loop")
+	}
+	label := p.jumpLabel()
+	p.consume(TokenTypeSemicolon, "expected ';' after 'continue'")
+	return ContinueStmt{label: label}
+}
+
+// Parses the optional label after break or continue, which must belong
+// to one of the enclosing loops
+func (p *Parser) jumpLabel() *Token {
+	if !p.match(TokenTypeIdentifier) {
+		return nil
+	}
+
+	label := p.previous()
+	for _, loop := range p.loops {
+		if loop != nil && loop.lexeme == label.lexeme {
+			return &label
+		}
+	}
+	if p.labels[label.lexeme] {
+		p.addError(label, "label can only be used inside its loop")
+	} else {
+		p.addError(label, "undefined label")
+	}
+	return &label
+}
+
+func (p *Parser) tryStatement() Stmt {
+	keyword := p.previous()
+	p.consume(TokenTypeLeftBrace, "expected '{' after 'try'")
+	body := p.block().(BlockStmt)
+
+	var catchName *Token = nil
+	var catchBody *BlockStmt = nil
+	if p.match(TokenTypeCatch) {
+		if p.match(TokenTypeLeftParen) {
+			name := p.consume(TokenTypeIdentifier, "expected exception variable name")
+			catchName = &name
+			p.consume(TokenTypeRightParen, "expected ')' after exception variable")
+		}
+		p.consume(TokenTypeLeftBrace, "expected '{' after 'catch'")
+		tmp := p.block().(BlockStmt)
+		catchBody = &tmp
+	}
+
+	var finallyBody *BlockStmt = nil
+	if p.match(TokenTypeFinally) {
+		p.consume(TokenTypeLeftBrace, "expected '{' after 'finally'")
+		tmp := p.block().(BlockStmt)
+		finallyBody = &tmp
+	}
+
+	if catchBody == nil && finallyBody == nil {
+		p.addError(keyword, "expected 'catch' or 'finally' after try block")
+	}
+
+	return TryStmt{
+		keyword:     keyword,
+		body:        body,
+		catchName:   catchName,
+		catchBody:   catchBody,
+		finallyBody: finallyBody,
+	}
+}
+
+func (p *Parser) throwStatement() Stmt {
+	keyword := p.previous()
+	value := p.expression()
+	p.consume(TokenTypeSemicolon, "expected ';' after thrown value")
+	return ThrowStmt{
+		keyword: keyword,
+		value:   value,
+	}
+}
+
+func (p *Parser) importStatement() Stmt {
+	keyword := p.previous()
+	path := p.consume(TokenTypeString, "expected module path after 'import'")
+	p.consumeContextual("as", "expected 'as' after module path")
+	name := p.consume(TokenTypeIdentifier, "expected module name after 'as'")
+	p.consume(TokenTypeSemicolon, "expected ';' after import")
+	return ImportStmt{
+		keyword:   keyword,
+		path:      path,
+		names:     []Token{name},
+		slots:     make([]int, 1),
+		selective: false,
+	}
+}
+
+func (p *Parser) fromImportStatement() Stmt {
+	keyword := p.previous()
+	path := p.consume(TokenTypeString, "expected module path after 'from'")
+	p.consume(TokenTypeImport, "expected 'import' after module path")
+	names := []Token{}
+	for {
+		names = append(names, p.consume(TokenTypeIdentifier, "expected name to import"))
+		if !p.match(TokenTypeComma) {
+			break
+		}
+	}
+	p.consume(TokenTypeSemicolon, "expected ';' after import")
+	return ImportStmt{
+		keyword:   keyword,
+		path:      path,
+		names:     names,
+		slots:     make([]int, len(names)),
+		selective: true,
+	}
 }
 
 func (p *Parser) block() Stmt {
@@ -362,6 +579,7 @@ func (p *Parser) block() Stmt {
 	}
 	stmt := BlockStmt{
 		statements: statements,
+		size:       new(int),
 	}
 	p.consume(TokenTypeRightBrace, "expected '}' after block")
 	return stmt
@@ -403,36 +621,100 @@ func (p *Parser) comma() Expr {
 	return expr
 }
 
+// Maps each compound assignment operator to the binary operator it applies
+var compoundAssignmentOperators = map[TokenType]TokenType{
+	TokenTypePlusEqual:    TokenTypePlus,
+	TokenTypeMinusEqual:   TokenTypeMinus,
+	TokenTypeStarEqual:    TokenTypeStar,
+	TokenTypeSlashEqual:   TokenTypeSlash,
+	TokenTypePercentEqual: TokenTypePercent,
+}
+
 func (p *Parser) assignment() Expr {
 	expr := p.ternary()
 
-	if p.match(TokenTypeEqual) {
+	if p.match(
+		TokenTypeEqual,
+		TokenTypePlusEqual,
+		TokenTypeMinusEqual,
+		TokenTypeStarEqual,
+		TokenTypeSlashEqual,
+		TokenTypePercentEqual,
+	) {
 		equals := p.previous()
 		value := p.assignment()
 
-		varExpr, ok := expr.(VariableExpr)
-		if ok {
-			name := varExpr.name
-			return AssignExpr{
-				name:     name,
-				value:    value,
-				distance: new(int),
+		var operator *Token
+		if ty, ok := compoundAssignmentOperators[equals.ty]; ok {
+			operator = &Token{
+				Position: equals.Position,
+				ty:       ty,
+				lexeme:   strings.TrimSuffix(equals.lexeme, "="),
 			}
 		}
+		return p.assignTo(expr, equals, operator, value, false)
+	}
 
-		getExpr, ok := expr.(GetExpr)
-		if ok {
-			return SetExpr{
-				object: getExpr.object,
-				name:   getExpr.name,
-				value:  value,
-			}
+	return expr
+}
+
+// Turns ++ or -- into an assignment that adds or subtracts one
+func (p *Parser) increment(target Expr, op Token, postfix bool) Expr {
+	operator := Token{
+		Position: op.Position,
+		ty:       TokenTypePlus,
+		lexeme:   "+",
+	}
+	if op.ty == TokenTypeMinusMinus {
+		operator.ty = TokenTypeMinus
+		operator.lexeme = "-"
+	}
+	return p.assignTo(target, op, &operator, LiteralExpr{value: int64(1)}, postfix)
+}
+
+// Builds an assignment of value to target, which must be a variable,
+// property or index. If operator is not nil, the value stored is the result
+// of applying it to the old value and value. If postfix is set, the
+// assignment evaluates to the old value rather than the one stored.
+func (p *Parser) assignTo(target Expr, equals Token, operator *Token, value Expr, postfix bool) Expr {
+	varExpr, ok := target.(VariableExpr)
+	if ok {
+		name := varExpr.name
+		return AssignExpr{
+			name:     name,
+			operator: operator,
+			value:    value,
+			postfix:  postfix,
+			distance: new(int),
+			slot:     new(int),
 		}
+	}
 
-		p.addError(equals, "invalid assignment target")
+	getExpr, ok := target.(GetExpr)
+	if ok {
+		return SetExpr{
+			object:   getExpr.object,
+			name:     getExpr.name,
+			operator: operator,
+			value:    value,
+			postfix:  postfix,
+		}
 	}
 
-	return expr
+	indexExpr, ok := target.(IndexExpr)
+	if ok {
+		return IndexSetExpr{
+			object:   indexExpr.object,
+			bracket:  indexExpr.bracket,
+			index:    indexExpr.index,
+			operator: operator,
+			value:    value,
+			postfix:  postfix,
+		}
+	}
+
+	p.addError(equals, "invalid assignment target")
+	return target
 }
 
 func (p *Parser) ternary() Expr {
@@ -504,7 +786,7 @@ func (p *Parser) equality() Expr {
 }
 
 func (p *Parser) comparison() Expr {
-	expr := p.term()
+	expr := p.bitwiseOr()
 
 	for p.match(
 		TokenTypeGreater,
@@ -512,6 +794,70 @@ func (p *Parser) comparison() Expr {
 		TokenTypeLess,
 		TokenTypeLessEqual,
 	) {
+		operator := p.previous()
+		right := p.bitwiseOr()
+		expr = BinaryExpr{
+			left:     expr,
+			operator: operator,
+			right:    right,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) bitwiseOr() Expr {
+	expr := p.bitwiseXor()
+
+	for p.match(TokenTypePipe) {
+		operator := p.previous()
+		right := p.bitwiseXor()
+		expr = BinaryExpr{
+			left:     expr,
+			operator: operator,
+			right:    right,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) bitwiseXor() Expr {
+	expr := p.bitwiseAnd()
+
+	for p.match(TokenTypeCaret) {
+		operator := p.previous()
+		right := p.bitwiseAnd()
+		expr = BinaryExpr{
+			left:     expr,
+			operator: operator,
+			right:    right,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) bitwiseAnd() Expr {
+	expr := p.shift()
+
+	for p.match(TokenTypeAmpersand) {
+		operator := p.previous()
+		right := p.shift()
+		expr = BinaryExpr{
+			left:     expr,
+			operator: operator,
+			right:    right,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) shift() Expr {
+	expr := p.term()
+
+	for p.match(TokenTypeLessLess, TokenTypeGreaterGreater) {
 		operator := p.previous()
 		right := p.term()
 		expr = BinaryExpr{
@@ -543,7 +889,7 @@ func (p *Parser) term() Expr {
 func (p *Parser) factor() Expr {
 	expr := p.unary()
 
-	for p.match(TokenTypeSlash, TokenTypeStar) {
+	for p.match(TokenTypeSlash, TokenTypeStar, TokenTypePercent, TokenTypeTildeSlash) {
 		operator := p.previous()
 		right := p.unary()
 		expr = BinaryExpr{
@@ -557,7 +903,7 @@ func (p *Parser) factor() Expr {
 }
 
 func (p *Parser) unary() Expr {
-	if p.match(TokenTypeBang, TokenTypeMinus) {
+	if p.match(TokenTypeBang, TokenTypeMinus, TokenTypeTilde) {
 		operator := p.previous()
 		right := p.unary()
 		return UnaryExpr{
@@ -566,7 +912,41 @@ func (p *Parser) unary() Expr {
 		}
 	}
 
-	return p.call()
+	if p.match(TokenTypePlusPlus, TokenTypeMinusMinus) {
+		op := p.previous()
+		target := p.unary()
+		return p.increment(target, op, false)
+	}
+
+	return p.power()
+}
+
+// ** is right associative and binds tighter than a unary operator on its
+// left, so -2 ** 2 is -4
+func (p *Parser) power() Expr {
+	expr := p.postfix()
+
+	if p.match(TokenTypeStarStar) {
+		operator := p.previous()
+		right := p.unary()
+		return BinaryExpr{
+			left:     expr,
+			operator: operator,
+			right:    right,
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) postfix() Expr {
+	expr := p.call()
+
+	if p.match(TokenTypePlusPlus, TokenTypeMinusMinus) {
+		return p.increment(expr, p.previous(), true)
+	}
+
+	return expr
 }
 
 func (p *Parser) call() Expr {
@@ -581,6 +961,14 @@ func (p *Parser) call() Expr {
 				object: expr,
 				name:   name,
 			}
+		} else if p.match(TokenTypeLeftBracket) {
+			index := p.expression()
+			bracket := p.consume(TokenTypeRightBracket, "expected ']' after index")
+			expr = IndexExpr{
+				object:  expr,
+				bracket: bracket,
+				index:   index,
+			}
 		} else {
 			break
 		}
@@ -591,12 +979,19 @@ func (p *Parser) call() Expr {
 
 func (p *Parser) finishCall(callee Expr) Expr {
 	arguments := []Expr{}
+	names := []Token{}
 	if !p.check(TokenTypeRightParen) {
 		for {
 			if len(arguments) >= 255 {
 				p.addError(p.peek(), "can't have more than 255 parameters")
 			}
 
+			if p.check(TokenTypeIdentifier) && p.checkNext(TokenTypeColon) {
+				names = append(names, p.advance())
+				p.advance()
+			} else if len(names) > 0 {
+				p.addError(p.peek(), "positional argument follows named argument")
+			}
 			arguments = append(arguments, p.assignment())
 			if !p.match(TokenTypeComma) {
 				break
@@ -609,6 +1004,77 @@ func (p *Parser) finishCall(callee Expr) Expr {
 		callee:    callee,
 		paren:     paren,
 		arguments: arguments,
+		names:     names,
+	}
+}
+
+func (p *Parser) listExpression() Expr {
+	bracket := p.previous()
+	elements := []Expr{}
+	for !p.check(TokenTypeRightBracket) {
+		elements = append(elements, p.assignment())
+		if !p.match(TokenTypeComma) {
+			break
+		}
+	}
+
+	p.consume(TokenTypeRightBracket, "expected ']' after list elements")
+	return ListExpr{
+		bracket:  bracket,
+		elements: elements,
+	}
+}
+
+func (p *Parser) mapExpression() Expr {
+	brace := p.previous()
+	keys := []Expr{}
+	values := []Expr{}
+	for !p.check(TokenTypeRightBrace) {
+		// Bare identifiers are shorthand for string keys. Otherwise the
+		// key can be any expression that binds tighter than the ternary
+		// operator, since that also uses ':'.
+		if p.check(TokenTypeIdentifier) && p.checkNext(TokenTypeColon) {
+			keys = append(keys, LiteralExpr{value: p.advance().lexeme})
+		} else {
+			keys = append(keys, p.or())
+		}
+		p.consume(TokenTypeColon, "expected ':' after map key")
+		values = append(values, p.assignment())
+		if !p.match(TokenTypeComma) {
+			break
+		}
+	}
+
+	p.consume(TokenTypeRightBrace, "expected '}' after map entries")
+	return MapExpr{
+		brace:  brace,
+		keys:   keys,
+		values: values,
+	}
+}
+
+func (p *Parser) interpolation() Expr {
+	start := p.previous()
+	parts := []Expr{LiteralExpr{value: start.literal}}
+	for {
+		parts = append(parts, p.expression())
+		if !p.match(TokenTypeInterpolationMid, TokenTypeInterpolationEnd) {
+			p.addError(p.peek(), "expected '}' after interpolated expression")
+			panic(unwindToken)
+		}
+
+		segment := p.previous()
+		if segment.literal.(string) != "" {
+			parts = append(parts, LiteralExpr{value: segment.literal})
+		}
+		if segment.ty == TokenTypeInterpolationEnd {
+			break
+		}
+	}
+
+	return InterpolationExpr{
+		start: start,
+		parts: parts,
 	}
 }
 
@@ -629,19 +1095,28 @@ func (p *Parser) primary() Expr {
 		return LiteralExpr{value: p.previous().literal}
 	}
 
+	if p.match(TokenTypeInterpolation) {
+		return p.interpolation()
+	}
+
 	if p.match(TokenTypeSuper) {
 		keyword := p.previous()
 		p.consume(TokenTypeDot, "expected '.' after 'super'")
 		method := p.consume(TokenTypeIdentifier, "expected method name")
-		return SuperExpr{keyword: keyword, method: method, distance: new(int)}
+		return SuperExpr{
+			keyword:  keyword,
+			method:   method,
+			distance: new(int),
+			slot:     new(int),
+		}
 	}
 
 	if p.match(TokenTypeThis) {
-		return ThisExpr{keyword: p.previous(), distance: new(int)}
+		return ThisExpr{keyword: p.previous(), distance: new(int), slot: new(int)}
 	}
 
 	if p.match(TokenTypeIdentifier) {
-		return VariableExpr{name: p.previous(), distance: new(int)}
+		return VariableExpr{name: p.previous(), distance: new(int), slot: new(int)}
 	}
 
 	if p.match(TokenTypeFun) {
@@ -654,6 +1129,14 @@ func (p *Parser) primary() Expr {
 		return GroupingExpr{expression: expr}
 	}
 
+	if p.match(TokenTypeLeftBracket) {
+		return p.listExpression()
+	}
+
+	if p.match(TokenTypeLeftBrace) {
+		return p.mapExpression()
+	}
+
 	p.addError(p.peek(), "expected expression")
 	panic(unwindToken)
 }
@@ -668,7 +1151,7 @@ func (p *Parser) consume(ty TokenType, message string) Token {
 }
 
 func (p *Parser) addError(t Token, message string) {
-	p.errors = append(p.errors, NewSyntaxError(t.line, &t, message))
+	p.errors = append(p.errors, NewSyntaxError(t.Position, &t, message))
 }
 
 func (p *Parser) synchronize() {
@@ -687,7 +1170,13 @@ func (p *Parser) synchronize() {
 			TokenTypeIf,
 			TokenTypeWhile,
 			TokenTypePrint,
-			TokenTypeReturn:
+			TokenTypeReturn,
+			TokenTypeTry,
+			TokenTypeThrow,
+			TokenTypeImport:
+			return
+		}
+		if p.checkContextual("from") && p.checkNext(TokenTypeString) {
 			return
 		}
 
@@ -712,6 +1201,32 @@ func (p *Parser) check(ty TokenType) bool {
 	return p.peek().ty == ty
 }
 
+// Whether the current token is the given contextual keyword, which is
+// scanned as an identifier since it is only a keyword in some places
+func (p *Parser) checkContextual(keyword string) bool {
+	return p.check(TokenTypeIdentifier) && p.peek().lexeme == keyword
+}
+
+func (p *Parser) consumeContextual(keyword string, message string) Token {
+	if p.checkContextual(keyword) {
+		return p.advance()
+	}
+
+	p.addError(p.peek(), message)
+	panic(unwindToken)
+}
+
+func (p *Parser) checkNext(ty TokenType) bool {
+	if p.isAtEnd() || p.current+1 >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+1].ty == ty
+}
+
+func (p *Parser) checkNextContextual(keyword string) bool {
+	return p.checkNext(TokenTypeIdentifier) && p.tokens[p.current+1].lexeme == keyword
+}
+
 func (p *Parser) advance() Token {
 	if !p.isAtEnd() {
 		p.current++
@@ -0,0 +1,90 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestCompoundAssignment(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "operators",
+			source: `
+				var x = 1;
+				x += 2;
+				x *= 3;
+				x -= 1;
+				x /= 2;
+				print x;
+				var s = "a";
+				s += "b";
+				print s;
+				print x += 1;
+			`,
+			output: "4.0\nab\n5.0\n",
+		},
+		{
+			name: "increment and decrement",
+			source: `
+				var x = 1;
+				print x++;
+				print x;
+				print ++x;
+				print x--;
+				print --x;
+				var xs = [x];
+				xs[0] -= 1;
+				print xs[0]++;
+				print ++xs[0];
+				class A {}
+				var a = A();
+				a.n = 1;
+				a.n += 1;
+				print a.n++;
+				print a.n;
+			`,
+			output: "1\n2\n3\n3\n1\n0\n2\n2\n3\n",
+		},
+		{
+			name: "receiver and index evaluated once",
+			source: `
+				var calls = 0;
+				var xs = [10, 20];
+				fun list() { calls = calls + 1; return xs; }
+				fun index() { calls = calls + 1; return 1; }
+				list()[index()] += 5;
+				list()[index()]++;
+				print xs;
+				print calls;
+				class A {}
+				var a = A();
+				a.n = 0;
+				fun get() { calls = calls + 1; return a; }
+				get().n += 1;
+				++get().n;
+				print a.n;
+				print calls;
+			`,
+			output: "[10, 26]\n4\n2\n6\n",
+		},
+		{
+			name:   "increment needs a number",
+			source: `var n = nil; n++;`,
+			err:    "<input>:1:15: runtime error: + operands must be numbers or strings",
+		},
+		{
+			name:   "invalid target",
+			source: `var a = 1; (a) += 1;`,
+			err:    "invalid assignment target",
+		},
+		{
+			name:   "invalid increment target",
+			source: `1++;`,
+			err:    "invalid assignment target",
+		},
+		{
+			name:   "invalid prefix target",
+			source: `var a = 1; ++(a + 1);`,
+			err:    "invalid assignment target",
+		},
+	})
+}
@@ -16,17 +16,30 @@ func newResolverError(token Token, message string) *ResolverError {
 	}
 }
 
+func (e *ResolverError) Position() Position {
+	return e.token.Position
+}
+
+func (e *ResolverError) Message() string {
+	return e.message
+}
+
+func (e *ResolverError) Error() string {
+	return e.String()
+}
+
 func (e *ResolverError) String() string {
 	return fmt.Sprintf(
-		"resolver error on line %d at %s: %s",
-		e.token.line,
+		"%v: resolver error at %s: %s",
+		e.token.Position,
 		e.token.lexeme,
 		e.message,
-	)
+	) + e.token.snippet()
 }
 
 type localVar struct {
 	token   *Token
+	slot    int
 	usages  int
 	defined bool
 }
@@ -90,17 +103,31 @@ func (r *Resolver) EndScope() {
 	r.scopes = r.scopes[:len(r.scopes)-1]
 }
 
-func (r *Resolver) Declare(name Token) {
+func (r *Resolver) isGlobalScope() bool {
+	return len(r.scopes) == 1
+}
+
+// Declares a variable in the current scope, returning the slot it will
+// occupy in the scope's environment, or -1 if it is a global.
+func (r *Resolver) Declare(name Token) int {
 	scope := r.currentScope()
-	_, ok := scope[name.lexeme]
+	v, ok := scope[name.lexeme]
 	if ok {
 		r.AddError(name, fmt.Sprintf("'%s' already declared in this scope", name.lexeme))
+		return v.slot
+	}
+
+	slot := -1
+	if !r.isGlobalScope() {
+		slot = len(scope)
 	}
 	scope[name.lexeme] = &localVar{
 		token:   &name,
+		slot:    slot,
 		usages:  0,
 		defined: false,
 	}
+	return slot
 }
 
 func (r *Resolver) Define(name Token) {
@@ -112,17 +139,25 @@ func (r *Resolver) Define(name Token) {
 	v.defined = true
 }
 
-func (r *Resolver) DeclareAndDefineNative(name string) {
+func (r *Resolver) DeclareAndDefineNative(name string) int {
 	scope := r.currentScope()
 	_, ok := scope[name]
 	if ok {
 		panic(fmt.Sprintf("duplicate declaration of '%s'", name))
 	}
+	slot := len(scope)
 	scope[name] = &localVar{
 		token:   nil,
+		slot:    slot,
 		usages:  1, // Since we're doing it, suppress unused errors
 		defined: true,
 	}
+	return slot
+}
+
+// Returns the number of slots needed for the current scope's environment.
+func (r *Resolver) ScopeSize() int {
+	return len(r.currentScope())
 }
 
 func (r *Resolver) IsDefined(name Token) bool {
@@ -131,22 +166,39 @@ func (r *Resolver) IsDefined(name Token) bool {
 	return !ok || v.defined
 }
 
-func (r *Resolver) ResolveLocal(name Token) int {
+// Returns the distance to the scope declaring the variable and its slot
+// within that scope, or -1 for both if the variable is a global.
+func (r *Resolver) ResolveLocal(name Token) (int, int) {
 	for i := range r.scopes {
 		scope := r.scopes[len(r.scopes)-1-i]
 		v, ok := scope[name.lexeme]
 		if ok {
 			v.usages++
-			return i
+			if v.slot < 0 {
+				return -1, -1
+			}
+			return i, v.slot
 		}
 	}
-	return -1
+	return -1, -1
 }
 
 func (r *Resolver) ResolveFunction(e FnExpr, ty FunctionType) {
 	oldTy := r.beginFunction(ty)
 	defer r.endFunction(oldTy)
 
+	// Defaults are evaluated where the function was defined, so they
+	// can't see the parameters
+	hasDefault := false
+	for i, param := range e.parameters {
+		if e.defaults[i] != nil {
+			e.defaults[i].Resolve(r)
+			hasDefault = true
+		} else if hasDefault && !(e.variadic && i == len(e.parameters)-1) {
+			r.AddError(param, "parameter without a default value follows one with a default value")
+		}
+	}
+
 	r.BeginScope()
 	defer r.EndScope()
 
@@ -158,6 +210,8 @@ func (r *Resolver) ResolveFunction(e FnExpr, ty FunctionType) {
 	for _, stmt := range e.body {
 		stmt.Resolve(r)
 	}
+
+	*e.size = r.ScopeSize()
 }
 
 func (r *Resolver) beginFunction(ty FunctionType) FunctionType {
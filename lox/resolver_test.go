@@ -0,0 +1,155 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "shadowing",
+			source: `
+				var a = "global";
+				{
+					var a = "outer";
+					{
+						var a = "inner";
+						print a;
+					}
+					print a;
+				}
+				print a;
+			`,
+			output: "inner\nouter\nglobal\n",
+		},
+		{
+			name: "closures over enclosing blocks",
+			source: `
+				fun make() {
+					var x = 1;
+					{
+						var y = 2;
+						{
+							var z = 3;
+							fun sum() { return x + y + z; }
+							return sum;
+						}
+					}
+				}
+				print make()();
+			`,
+			output: "6\n",
+		},
+		{
+			name: "closures keep the binding they resolved",
+			source: `
+				var a = "global";
+				{
+					fun show() { print a; }
+					show();
+					var a = "block";
+					show();
+					print a;
+				}
+			`,
+			output: "global\nglobal\nblock\n",
+		},
+		{
+			name: "this and super",
+			source: `
+				class A {
+					name() { return "A"; }
+				}
+				class B < A {
+					name() {
+						var prefix = super.name();
+						var self = this;
+						fun inner() { return prefix + self.suffix; }
+						return inner;
+					}
+				}
+				var b = B();
+				b.suffix = "!";
+				print b.name()();
+			`,
+			output: "A!\n",
+		},
+		{
+			name: "globals defined after use",
+			source: `
+				fun f() { return later; }
+				var later = "ok";
+				print f();
+			`,
+			output: "ok\n",
+		},
+		{
+			name:   "own initializer",
+			source: `{ var a = 1; { var a = a; print a; } }`,
+			err:    "cannot refer to 'a' in its own initializer",
+		},
+		{
+			name:   "redeclared",
+			source: `{ var a = 1; var a = 2; print a; }`,
+			err:    "'a' already declared in this scope",
+		},
+		{
+			name:   "unused",
+			source: `{ var unused = 1; var _ignored = 2; }`,
+			err:    "'unused' declared but not used",
+		},
+		{
+			name:   "this outside method",
+			source: `print this;`,
+			err:    "cannot use this outside method",
+		},
+		{
+			name:   "return from initializer",
+			source: `class A { init() { return 1; } }`,
+			err:    "cannot return value from initializer",
+		},
+	})
+}
+
+// Runs source once per iteration with each engine
+func benchmarkScript(b *testing.B, source string) {
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := engine.new().Eval(source); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Dominated by function calls and variable lookups
+func BenchmarkFib(b *testing.B) {
+	benchmarkScript(b, `
+		fun fib(n) {
+			if (n < 2) return n;
+			return fib(n - 1) + fib(n - 2);
+		}
+		fib(20);
+	`)
+}
+
+// Dominated by block scopes and assignments
+func BenchmarkLoop(b *testing.B) {
+	benchmarkScript(b, `
+		fun loop(n) {
+			var total = 0;
+			for (var i = 0; i < n; i = i + 1) {
+				var j = 0;
+				while (j < 10) {
+					var k = i + j;
+					total = total + k;
+					j = j + 1;
+				}
+			}
+			return total;
+		}
+		loop(10000);
+	`)
+}
@@ -1,62 +1,122 @@
 package lox
 
 import (
+	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var keywords = map[string]TokenType{
-	"and":    TokenTypeAnd,
-	"class":  TokenTypeClass,
-	"else":   TokenTypeElse,
-	"false":  TokenTypeFalse,
-	"for":    TokenTypeFor,
-	"fun":    TokenTypeFun,
-	"if":     TokenTypeIf,
-	"nil":    TokenTypeNil,
-	"or":     TokenTypeOr,
-	"print":  TokenTypePrint,
-	"return": TokenTypeReturn,
-	"super":  TokenTypeSuper,
-	"this":   TokenTypeThis,
-	"true":   TokenTypeTrue,
-	"var":    TokenTypeVar,
-	"while":  TokenTypeWhile,
-	"break":  TokenTypeBreak,
+	"and":      TokenTypeAnd,
+	"class":    TokenTypeClass,
+	"else":     TokenTypeElse,
+	"false":    TokenTypeFalse,
+	"for":      TokenTypeFor,
+	"fun":      TokenTypeFun,
+	"if":       TokenTypeIf,
+	"nil":      TokenTypeNil,
+	"or":       TokenTypeOr,
+	"print":    TokenTypePrint,
+	"return":   TokenTypeReturn,
+	"super":    TokenTypeSuper,
+	"this":     TokenTypeThis,
+	"true":     TokenTypeTrue,
+	"var":      TokenTypeVar,
+	"while":    TokenTypeWhile,
+	"break":    TokenTypeBreak,
+	"continue": TokenTypeContinue,
+	"try":      TokenTypeTry,
+	"catch":    TokenTypeCatch,
+	"finally":  TokenTypeFinally,
+	"throw":    TokenTypeThrow,
+	"import":   TokenTypeImport,
 }
 
 type Scanner struct {
-	source  string
-	tokens  []Token
-	start   int
-	current int
-	line    int
-	errors  []*SyntaxError
+	file           *Source
+	source         string
+	tokens         []Token
+	start          int
+	startLine      int
+	startLineStart int
+	current        int
+	line           int
+	lineStart      int
+	errors         []*SyntaxError
+
+	// For each string interpolation being scanned, how many braces deep
+	// into its expression the scanner is
+	interpolations []int
 }
 
-func NewScanner(source string) *Scanner {
+// Scans the source of the named file, which is only used for errors
+func NewScanner(name string, source string) *Scanner {
 	return &Scanner{
-		source:  source,
-		tokens:  []Token{},
-		start:   0,
-		current: 0,
-		line:    1,
+		file:           NewSource(name, source),
+		source:         source,
+		tokens:         []Token{},
+		start:          0,
+		startLine:      1,
+		startLineStart: 0,
+		current:        0,
+		line:           1,
+		lineStart:      0,
+		errors:         nil,
+		interpolations: []int{},
 	}
 }
 
 func (s *Scanner) ScanTokens() ([]Token, []*SyntaxError) {
 	for !s.isAtEnd() {
 		s.start = s.current
+		s.startLine = s.line
+		s.startLineStart = s.lineStart
 		s.scanToken()
 	}
+	s.start = s.current
+	s.startLine = s.line
+	s.startLineStart = s.lineStart
 	s.tokens = append(s.tokens, Token{
-		ty:      TokenTypeEOF,
-		lexeme:  "(EOF)",
-		literal: nil,
-		line:    s.line,
+		Position: s.position(0),
+		ty:       TokenTypeEOF,
+		lexeme:   "(EOF)",
+		literal:  nil,
 	})
 	return s.tokens, s.errors
 }
 
+// Returns the position of the token being scanned, or the given number of
+// bytes at its start
+func (s *Scanner) position(length int) Position {
+	return s.positionAt(s.start, s.startLine, s.startLineStart, length)
+}
+
+// Returns the position of the given number of bytes at offset, which is on
+// the line with the given number that starts at lineStart
+func (s *Scanner) positionAt(offset int, line int, lineStart int, length int) Position {
+	return Position{
+		source: s.file,
+		offset: offset,
+		line:   line,
+		column: utf8.RuneCountInString(s.source[lineStart:offset]) + 1,
+		length: length,
+	}
+}
+
+func (s *Scanner) addError(length int, message string) {
+	s.errors = append(s.errors, NewSyntaxError(s.position(length), nil, message))
+}
+
+// Adds an error about the part of the current line from offset up to the
+// current character
+func (s *Scanner) addErrorFrom(offset int, message string) {
+	position := s.positionAt(offset, s.line, s.lineStart, s.current-offset)
+	s.errors = append(s.errors, NewSyntaxError(position, nil, message))
+}
+
 func (s *Scanner) scanToken() {
 	c := s.advance()
 	switch c {
@@ -65,21 +125,80 @@ func (s *Scanner) scanToken() {
 	case ')':
 		s.addToken(TokenTypeRightParen)
 	case '{':
+		if len(s.interpolations) > 0 {
+			s.interpolations[len(s.interpolations)-1]++
+		}
 		s.addToken(TokenTypeLeftBrace)
 	case '}':
+		if len(s.interpolations) > 0 {
+			depth := &s.interpolations[len(s.interpolations)-1]
+			if *depth == 0 {
+				// End of the expression, so carry on with the string
+				s.interpolations = s.interpolations[:len(s.interpolations)-1]
+				s.scanStringPart(TokenTypeInterpolationMid, TokenTypeInterpolationEnd)
+				return
+			}
+			*depth--
+		}
 		s.addToken(TokenTypeRightBrace)
+	case '[':
+		s.addToken(TokenTypeLeftBracket)
+	case ']':
+		s.addToken(TokenTypeRightBracket)
 	case ',':
 		s.addToken(TokenTypeComma)
 	case '.':
-		s.addToken(TokenTypeDot)
+		if s.peek() == '.' && s.peekNext() == '.' {
+			s.advance()
+			s.advance()
+			s.addToken(TokenTypeDotDotDot)
+		} else {
+			s.addToken(TokenTypeDot)
+		}
 	case '-':
-		s.addToken(TokenTypeMinus)
+		if s.match('=') {
+			s.addToken(TokenTypeMinusEqual)
+		} else if s.match('-') {
+			s.addToken(TokenTypeMinusMinus)
+		} else {
+			s.addToken(TokenTypeMinus)
+		}
 	case '+':
-		s.addToken(TokenTypePlus)
+		if s.match('=') {
+			s.addToken(TokenTypePlusEqual)
+		} else if s.match('+') {
+			s.addToken(TokenTypePlusPlus)
+		} else {
+			s.addToken(TokenTypePlus)
+		}
 	case ';':
 		s.addToken(TokenTypeSemicolon)
 	case '*':
-		s.addToken(TokenTypeStar)
+		if s.match('=') {
+			s.addToken(TokenTypeStarEqual)
+		} else if s.match('*') {
+			s.addToken(TokenTypeStarStar)
+		} else {
+			s.addToken(TokenTypeStar)
+		}
+	case '~':
+		if s.match('/') {
+			s.addToken(TokenTypeTildeSlash)
+		} else {
+			s.addToken(TokenTypeTilde)
+		}
+	case '&':
+		s.addToken(TokenTypeAmpersand)
+	case '|':
+		s.addToken(TokenTypePipe)
+	case '^':
+		s.addToken(TokenTypeCaret)
+	case '%':
+		if s.match('=') {
+			s.addToken(TokenTypePercentEqual)
+		} else {
+			s.addToken(TokenTypePercent)
+		}
 	case '!':
 		if s.match('=') {
 			s.addToken(TokenTypeBangEqual)
@@ -95,12 +214,16 @@ func (s *Scanner) scanToken() {
 	case '<':
 		if s.match('=') {
 			s.addToken(TokenTypeLessEqual)
+		} else if s.match('<') {
+			s.addToken(TokenTypeLessLess)
 		} else {
 			s.addToken(TokenTypeLess)
 		}
 	case '>':
 		if s.match('=') {
 			s.addToken(TokenTypeGreaterEqual)
+		} else if s.match('>') {
+			s.addToken(TokenTypeGreaterGreater)
 		} else {
 			s.addToken(TokenTypeGreater)
 		}
@@ -113,24 +236,32 @@ func (s *Scanner) scanToken() {
 			s.scanLineComment()
 		} else if s.match('*') {
 			s.scanBlockComment()
+		} else if s.match('=') {
+			s.addToken(TokenTypeSlashEqual)
 		} else {
 			s.addToken(TokenTypeSlash)
 		}
 	case ' ', '\r', '\t':
 		// Ignore whitespace
 	case '\n':
-		s.line++
+		// Lines are counted by advance
 	case '"':
-		s.scanString()
+		if s.peek() == '"' && s.peekNext() == '"' {
+			s.advance()
+			s.advance()
+			s.scanTripleQuotedString()
+		} else {
+			s.scanStringPart(TokenTypeInterpolation, TokenTypeString)
+		}
+	case '`':
+		s.scanRawString()
 	default:
 		if isDigit(c) {
 			s.scanNumber()
 		} else if isAlpha(c) {
 			s.scanIdentifier()
 		} else {
-			s.errors = append(s.errors, NewSyntaxError(
-				s.line, nil, "unexpected character",
-			))
+			s.addError(s.current-s.start, "unexpected character")
 		}
 	}
 }
@@ -161,26 +292,126 @@ func (s *Scanner) scanBlockComment() {
 }
 
 func (s *Scanner) scanNumber() {
-	for isDigit(s.peek()) {
-		s.advance()
+	if s.source[s.start] == '0' {
+		switch s.peek() {
+		case 'x', 'X':
+			s.scanRadixNumber(16, "hexadecimal", isHexDigit)
+			return
+		case 'b', 'B':
+			s.scanRadixNumber(2, "binary", isBinaryDigit)
+			return
+		case 'o', 'O':
+			s.scanRadixNumber(8, "octal", isOctalDigit)
+			return
+		}
 	}
 
-	// Check for a decimal point with a numeric value after it
+	// The first digit has already been consumed
+	if !s.scanDigits(s.start, isDigit) {
+		return
+	}
+
+	// Check for a decimal point with a numeric value after it, which
+	// makes it a float rather than an integer
+	float := false
 	if s.peek() == '.' && isDigit(s.peekNext()) {
+		float = true
 		s.advance()
-		for isDigit(s.peek()) {
+		if !s.scanDigits(s.current, isDigit) {
+			return
+		}
+	}
+
+	if s.peek() == 'e' || s.peek() == 'E' {
+		float = true
+		s.advance()
+		if s.peek() == '+' || s.peek() == '-' {
 			s.advance()
 		}
+		if !isDigit(s.peek()) {
+			s.addErrorFrom(s.start, "expected digits in exponent")
+			return
+		}
+		if !s.scanDigits(s.current, isDigit) {
+			return
+		}
+	}
+
+	if isAlphaNumeric(s.peek()) {
+		for isAlphaNumeric(s.peek()) {
+			s.advance()
+		}
+		s.addErrorFrom(s.start, "invalid number literal")
+		return
 	}
 
-	num, err := strconv.ParseFloat(s.source[s.start:s.current], 64)
+	text := strings.ReplaceAll(s.source[s.start:s.current], "_", "")
+	if !float {
+		s.addIntegerToken(text, 10)
+		return
+	}
+
+	num, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		panic(err)
+		s.addErrorFrom(s.start, "number literal out of range")
+		return
 	}
 
 	s.addTokenWithLiteral(TokenTypeNumber, num)
 }
 
+// Scans the rest of a 0x, 0b or 0o literal
+func (s *Scanner) scanRadixNumber(base int, name string, isDigit func(rune) bool) {
+	s.advance()
+	digitsStart := s.current
+	if !isAlphaNumeric(s.peek()) || s.peek() == '_' {
+		s.addErrorFrom(s.start, fmt.Sprintf("expected digits after '%s'", s.source[s.start:s.current]))
+		return
+	}
+	if !s.scanDigits(s.current, isDigit) {
+		return
+	}
+
+	if isAlphaNumeric(s.peek()) {
+		c := s.advance()
+		s.addErrorFrom(s.start, fmt.Sprintf("invalid digit '%c' in %s literal", c, name))
+		return
+	}
+
+	s.addIntegerToken(strings.ReplaceAll(s.source[digitsStart:s.current], "_", ""), base)
+}
+
+// Adds an integer literal, which is an int64 if it fits in one or a
+// *big.Int otherwise
+func (s *Scanner) addIntegerToken(digits string, base int) {
+	num, err := strconv.ParseInt(digits, base, 64)
+	if err == nil {
+		s.addTokenWithLiteral(TokenTypeNumber, num)
+		return
+	}
+	bigNum, _ := new(big.Int).SetString(digits, base)
+	s.addTokenWithLiteral(TokenTypeNumber, bigNum)
+}
+
+// Consumes a run of digits starting at the given offset, which may be
+// separated by single underscores. Reports an error and returns false if an
+// underscore is anywhere else.
+func (s *Scanner) scanDigits(start int, isDigit func(rune) bool) bool {
+	for isDigit(s.peek()) || s.peek() == '_' {
+		s.advance()
+	}
+
+	digits := s.source[start:s.current]
+	if strings.HasPrefix(digits, "_") ||
+		strings.HasSuffix(digits, "_") ||
+		strings.Contains(digits, "__") {
+
+		s.addErrorFrom(s.start, "'_' must be between digits in a number literal")
+		return false
+	}
+	return true
+}
+
 func (s *Scanner) scanIdentifier() {
 	for isAlphaNumeric(s.peek()) {
 		s.advance()
@@ -196,40 +427,196 @@ func (s *Scanner) scanIdentifier() {
 	s.addToken(ty)
 }
 
-func (s *Scanner) scanString() {
+// Scans a string, or the part of one up to the next ${ if it contains
+// interpolated expressions. A part that ends at a ${ gets the type open,
+// and one that ends the string gets the type end. The parts after the
+// first start with the } ending the previous expression, and get their own
+// types so that the parser can never take them for string literals.
+func (s *Scanner) scanStringPart(open TokenType, end TokenType) {
+	var value strings.Builder
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		offset := s.current
+		c := s.advance()
+		if c == '\\' {
+			s.scanEscape(offset, &value)
+		} else if c == '$' && s.match('{') {
+			s.interpolations = append(s.interpolations, 0)
+			s.addTokenWithLiteral(open, value.String())
+			return
+		} else {
+			value.WriteString(s.source[offset:s.current])
 		}
-		s.advance()
 	}
 
 	if s.isAtEnd() {
-		s.errors = append(s.errors, NewSyntaxError(
-			s.line, nil, "unterminated string",
-		))
+		s.addError(1, "unterminated string")
 		return
 	}
 
 	// Consume closing quote
 	s.advance()
 
-	value := s.source[s.start+1 : s.current-1]
-	s.addTokenWithLiteral(TokenTypeString, value)
+	s.addTokenWithLiteral(end, value.String())
+}
+
+// Scans a string between backticks, which is kept exactly as written,
+// including any line breaks
+func (s *Scanner) scanRawString() {
+	for s.peek() != '`' && !s.isAtEnd() {
+		s.advance()
+	}
+
+	if s.isAtEnd() {
+		s.addError(1, "unterminated string")
+		return
+	}
+
+	// Consume closing backtick
+	s.advance()
+
+	s.addTokenWithLiteral(TokenTypeString, s.source[s.start+1:s.current-1])
+}
+
+// Scans a string between triple quotes, which is kept as written, except
+// that it is dedented if it starts on the line after the opening quotes
+func (s *Scanner) scanTripleQuotedString() {
+	for !strings.HasPrefix(s.source[s.current:], `"""`) && !s.isAtEnd() {
+		s.advance()
+	}
+
+	if s.isAtEnd() {
+		s.addError(3, "unterminated string")
+		return
+	}
+
+	value := s.source[s.start+3 : s.current]
+	s.advance()
+	s.advance()
+	s.advance()
+
+	s.addTokenWithLiteral(TokenTypeString, dedent(value))
+}
+
+// If text starts with a line break, removes it along with the indentation
+// common to all of the lines after it. Lines that are only whitespace
+// don't count, except for the last one, which is where the closing quotes
+// are and so is made empty.
+func dedent(text string) string {
+	if !strings.HasPrefix(text, "\n") && !strings.HasPrefix(text, "\r\n") {
+		return text
+	}
+
+	lines := strings.Split(text[strings.IndexByte(text, '\n')+1:], "\n")
+	indent := ""
+	found := false
+	for i, line := range lines {
+		content := strings.TrimLeft(line, " \t")
+		if strings.TrimRight(content, "\r") == "" && i < len(lines)-1 {
+			continue
+		}
+		lineIndent := line[:len(line)-len(content)]
+		if !found {
+			indent = lineIndent
+			found = true
+		}
+		for !strings.HasPrefix(lineIndent, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, indent) {
+			lines[i] = line[len(indent):]
+		} else {
+			lines[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+	if strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines[len(lines)-1] = ""
+	}
+	return strings.Join(lines, "\n")
+}
+
+var escapes = map[rune]string{
+	'n':  "\n",
+	't':  "\t",
+	'r':  "\r",
+	'0':  "\x00",
+	'\\': "\\",
+	'"':  "\"",
+	'$':  "$",
+}
+
+// Scans the rest of an escape sequence starting with the backslash at
+// offset, writing the character it stands for to value
+func (s *Scanner) scanEscape(offset int, value *strings.Builder) {
+	c := s.peek()
+	if c == '\n' || s.isAtEnd() {
+		s.addErrorFrom(offset, "invalid escape sequence")
+		return
+	}
+	s.advance()
+
+	if c == 'u' {
+		s.scanUnicodeEscape(offset, value)
+		return
+	}
+
+	escaped, ok := escapes[c]
+	if !ok {
+		s.addErrorFrom(offset, "invalid escape sequence")
+		return
+	}
+	value.WriteString(escaped)
+}
+
+// Scans the rest of a \u{XXXX} escape, where XXXX is 1-6 hex digits
+func (s *Scanner) scanUnicodeEscape(offset int, value *strings.Builder) {
+	if !s.match('{') {
+		s.addErrorFrom(offset, "expected '{' after \\u")
+		return
+	}
+
+	digitsStart := s.current
+	for isHexDigit(s.peek()) {
+		s.advance()
+	}
+	digits := s.source[digitsStart:s.current]
+	if !s.match('}') {
+		s.addErrorFrom(offset, "expected hex digits and '}' in unicode escape")
+		return
+	}
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if len(digits) == 0 || len(digits) > 6 || err != nil || !utf8.ValidRune(rune(code)) {
+		s.addErrorFrom(offset, "invalid unicode code point")
+		return
+	}
+	value.WriteRune(rune(code))
 }
 
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c rune) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isBinaryDigit(c rune) bool {
+	return c == '0' || c == '1'
+}
+
+func isOctalDigit(c rune) bool {
+	return c >= '0' && c <= '7'
+}
+
 func isAlpha(c rune) bool {
-	return c >= 'a' && c <= 'z' ||
-		c >= 'A' && c <= 'Z' ||
-		c == '_'
+	return unicode.IsLetter(c) || c == '_'
 }
 
 func isAlphaNumeric(c rune) bool {
-	return isAlpha(c) || isDigit(c)
+	return isAlpha(c) || unicode.IsDigit(c)
 }
 
 func (s *Scanner) match(expected rune) bool {
@@ -237,26 +624,35 @@ func (s *Scanner) match(expected rune) bool {
 		return false
 	}
 
-	if rune(s.source[s.current]) != expected {
+	if s.peek() != expected {
 		return false
 	}
 
-	s.current++
+	s.advance()
 	return true
 }
 
-func (s *Scanner) peek() rune {
-	if s.isAtEnd() {
-		return '\x00'
+// Returns the character at the given byte offset and its size in bytes.
+// Invalid UTF-8 is returned as utf8.RuneError one byte at a time.
+func (s *Scanner) runeAt(offset int) (rune, int) {
+	if offset >= len(s.source) {
+		return '\x00', 0
 	}
-	return rune(s.source[s.current])
+	return utf8.DecodeRuneInString(s.source[offset:])
+}
+
+func (s *Scanner) peek() rune {
+	c, _ := s.runeAt(s.current)
+	return c
 }
 
 func (s *Scanner) peekNext() rune {
-	if s.current+1 >= len(s.source) {
+	_, size := s.runeAt(s.current)
+	if size == 0 {
 		return '\x00'
 	}
-	return rune(s.source[s.current+1])
+	c, _ := s.runeAt(s.current + size)
+	return c
 }
 
 func (s *Scanner) isAtEnd() bool {
@@ -264,9 +660,13 @@ func (s *Scanner) isAtEnd() bool {
 }
 
 func (s *Scanner) advance() rune {
-	c := s.source[s.current]
-	s.current++
-	return rune(c)
+	c, size := s.runeAt(s.current)
+	s.current += size
+	if c == '\n' {
+		s.line++
+		s.lineStart = s.current
+	}
+	return c
 }
 
 func (s *Scanner) addToken(t TokenType) {
@@ -276,9 +676,9 @@ func (s *Scanner) addToken(t TokenType) {
 func (s *Scanner) addTokenWithLiteral(ty TokenType, literal interface{}) {
 	text := s.source[s.start:s.current]
 	s.tokens = append(s.tokens, Token{
-		ty:      ty,
-		lexeme:  text,
-		literal: literal,
-		line:    s.line,
+		Position: s.position(len(text)),
+		ty:       ty,
+		lexeme:   text,
+		literal:  literal,
 	})
 }
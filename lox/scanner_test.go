@@ -0,0 +1,193 @@
+package lox
+
+import (
+	"testing"
+)
+
+type scanTest struct {
+	source  string
+	literal interface{}
+	err     string
+}
+
+// Scans each source, which should be a single literal, and checks its value
+// or the first error
+func runScanTests(t *testing.T, tests []scanTest) {
+	for _, test := range tests {
+		tokens, errs := NewScanner("<input>", test.source).ScanTokens()
+		if test.err != "" {
+			if len(errs) == 0 || errs[0].Message() != test.err {
+				t.Errorf("scanning %s: got errors %v, want %q", test.source, errs, test.err)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("scanning %s: %v", test.source, errs[0])
+			continue
+		}
+		if len(tokens) != 2 || tokens[0].literal != test.literal {
+			t.Errorf("scanning %s: got tokens %v, want one with literal %#v", test.source, tokens, test.literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	runScanTests(t, []scanTest{
+		{source: `"a\tb\nc\r\\\"\0"`, literal: "a\tb\nc\r\\\"\x00"},
+		{source: `"\$\{"`, err: "invalid escape sequence"},
+		{source: `"\${x}"`, literal: "${x}"},
+		{source: `"\u{41}\u{e9}\u{1F600}"`, literal: "Aé\U0001F600"},
+		{source: `"\u{10FFFF}"`, literal: "\U0010FFFF"},
+		{source: `"\q"`, err: "invalid escape sequence"},
+		{source: "\"\\\n\"", err: "invalid escape sequence"},
+		{source: `"\u41"`, err: "expected '{' after \\u"},
+		{source: `"\u{41"`, err: "expected hex digits and '}' in unicode escape"},
+		{source: `"\u{}"`, err: "invalid unicode code point"},
+		{source: `"\u{D800}"`, err: "invalid unicode code point"},
+		{source: `"\u{110000}"`, err: "invalid unicode code point"},
+		{source: `"\u{0000041}"`, err: "invalid unicode code point"},
+		{source: `"abc\`, err: "invalid escape sequence"},
+	})
+}
+
+func TestStringEscapesInScripts(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name:   "escapes",
+			source: "print \"a\\tb \\u{1F600}\";\nprint \"\\\"quoted\\\" \\\\ \\$\";",
+			output: "a\tb \U0001F600\n\"quoted\" \\ $\n",
+		},
+		{
+			name:   "invalid escape",
+			source: `print "ok\q";`,
+			err:    "<input>:1:10: syntax error: invalid escape sequence\n    print \"ok\\q\";\n             ^~",
+		},
+	})
+}
+
+func TestInterpolation(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "expressions",
+			source: `
+				var x = 2;
+				print "x = ${x}, x + 1 = ${x + 1}!";
+				print "${x}${x}";
+				print "${[1, "a"]} ${nil} ${1.5} ${true}";
+				print "${"nested ${x * 10}"}";
+				print "${{"k": "v"}["k"]}";
+				print "${fun () { return "fn"; }()}";
+				print "no ${"}"} brace";
+				print "$x and $ and {x}";
+			`,
+			output: "x = 2, x + 1 = 3!\n22\n[1, \"a\"] nil 1.5 true\nnested 20\nv\nfn\nno } brace\n$x and $ and {x}\n",
+		},
+		{
+			name: "evaluated in order",
+			source: `
+				var log = [];
+				fun f(x) { log.push(x); return x; }
+				print "${f(1)} ${f(2)}";
+				print log;
+			`,
+			output: "1 2\n[1, 2]\n",
+		},
+		{
+			name:   "incomplete expression",
+			source: `print "a ${1 +} b";`,
+			err:    "<input>:1:15: syntax error",
+		},
+		{
+			name:   "empty expression",
+			source: `print "${}";`,
+			err:    "expected expression",
+		},
+		{
+			name:   "missing brace",
+			source: `print "${1 2}";`,
+			err:    "expected '}' after interpolated expression",
+		},
+		{
+			name:   "unterminated",
+			source: `print "${1} and more`,
+			err:    "unterminated string",
+		},
+	})
+}
+
+func TestRawStrings(t *testing.T) {
+	runScanTests(t, []scanTest{
+		{source: "`a\\tb ${x} \"q\"`", literal: "a\\tb ${x} \"q\""},
+		{source: "`two\n  lines`", literal: "two\n  lines"},
+		{source: "``", literal: ""},
+		{source: "`open", err: "unterminated string"},
+		{source: `"""no \n escapes"""`, literal: "no \\n escapes"},
+		{source: `"""say "hi" """`, literal: "say \"hi\" "},
+		{source: "\"\"\"\n    two\n      indented\n    lines\n    \"\"\"", literal: "two\n  indented\nlines\n"},
+		{source: "\"\"\"\n\ttabs\n\n\t\tkept\"\"\"", literal: "tabs\n\n\tkept"},
+		{source: "\"\"\"\r\n  crlf\r\n  lines\"\"\"", literal: "crlf\r\nlines"},
+		{source: "\"\"\"  first line\n  is not dedented\"\"\"", literal: "  first line\n  is not dedented"},
+		{source: `"""open""`, err: "unterminated string"},
+	})
+}
+
+func TestRawStringsInScripts(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "raw and triple-quoted",
+			source: "var x = 2;\n" +
+				"print `raw ${x}\\n`;\n" +
+				"print \"\"\"\n  two\n  lines\"\"\";\n",
+			output: "raw ${x}\\n\ntwo\nlines\n",
+		},
+		{
+			name:   "lines after a multi-line string",
+			source: "var s = `a\nb\nc`;\nvar t = \"\"\"\n  d\n  \"\"\";\nprint s + t;\nnil.x;",
+			output: "a\nb\nc\nd\n\n",
+			err:    "<input>:8:5: runtime error",
+		},
+	})
+}
+
+func TestNumberLiterals(t *testing.T) {
+	runScanTests(t, []scanTest{
+		{source: "0", literal: int64(0)},
+		{source: "1_000_000", literal: int64(1000000)},
+		{source: "0xFF", literal: int64(255)},
+		{source: "0Xdead_beef", literal: int64(0xdeadbeef)},
+		{source: "0b1010", literal: int64(10)},
+		{source: "0o17", literal: int64(15)},
+		{source: "1.5", literal: 1.5},
+		{source: "1e3", literal: 1000.0},
+		{source: "2.5E-1", literal: 0.25},
+		{source: "1_0.0_1", literal: 10.01},
+		{source: "0x", err: "expected digits after '0x'"},
+		{source: "0b_1", err: "expected digits after '0b'"},
+		{source: "0b102", err: "invalid digit '2' in binary literal"},
+		{source: "0o8", err: "invalid digit '8' in octal literal"},
+		{source: "0xFG", err: "invalid digit 'G' in hexadecimal literal"},
+		{source: "1e", err: "expected digits in exponent"},
+		{source: "1e+", err: "expected digits in exponent"},
+		{source: "12abc", err: "invalid number literal"},
+		{source: "1_", err: "'_' must be between digits in a number literal"},
+		{source: "1__0", err: "'_' must be between digits in a number literal"},
+		{source: "1.5_", err: "'_' must be between digits in a number literal"},
+		{source: "1e400", err: "number literal out of range"},
+	})
+}
+
+func TestNumberLiteralsInScripts(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "literals",
+			source: `
+				print 0xFF + 0b11 + 0o7 + 1_000;
+				print 0xFFFF_FFFF_FFFF_FFFF_FF;
+				print 123456789012345678901234567890;
+				print 1.0;
+				print 1e3;
+			`,
+			output: "1265\n4722366482869645213695\n123456789012345678901234567890\n1.0\n1000.0\n",
+		},
+	})
+}
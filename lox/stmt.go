@@ -7,6 +7,7 @@ import (
 type Stmt interface {
 	Execute(env *Environment) RuntimeException
 	Resolve(r *Resolver)
+	Compile(c *Compiler)
 }
 
 type ExprStmt struct {
@@ -22,6 +23,11 @@ func (s ExprStmt) Resolve(r *Resolver) {
 	s.expression.Resolve(r)
 }
 
+func (s ExprStmt) Compile(c *Compiler) {
+	s.expression.Compile(c)
+	c.emitOp(OpPop)
+}
+
 type PrintStmt struct {
 	expression Expr
 }
@@ -32,7 +38,7 @@ func (s PrintStmt) Execute(env *Environment) RuntimeException {
 		return err
 	}
 
-	fmt.Println(val.String())
+	fmt.Fprintln(env.ancestor(-1).streams.stdout, val.String())
 	return nil
 }
 
@@ -40,38 +46,56 @@ func (s PrintStmt) Resolve(r *Resolver) {
 	s.expression.Resolve(r)
 }
 
+func (s PrintStmt) Compile(c *Compiler) {
+	s.expression.Compile(c)
+	c.emitOp(OpPrint)
+}
+
 type VarStmt struct {
 	name        Token
 	initializer *Expr
+	slot        *int
 }
 
 func (s VarStmt) Execute(env *Environment) RuntimeException {
 	if s.initializer == nil {
-		env.Declare(s.name)
+		env.Declare(*s.slot, s.name)
 	} else {
 		value, err := (*s.initializer).Evaluate(env)
 		if err != nil {
 			return err
 		}
-		env.Define(s.name, value)
+		env.Define(*s.slot, s.name, value)
 	}
 	return nil
 }
 
 func (s VarStmt) Resolve(r *Resolver) {
-	r.Declare(s.name)
+	*s.slot = r.Declare(s.name)
 	if s.initializer != nil {
 		(*s.initializer).Resolve(r)
 	}
 	r.Define(s.name)
 }
 
+func (s VarStmt) Compile(c *Compiler) {
+	c.declareVariable(s.name)
+	if s.initializer == nil {
+		c.emitOp(OpUninitialized)
+	} else {
+		(*s.initializer).Compile(c)
+	}
+	c.setPosition(s.name)
+	c.defineVariable(s.name)
+}
+
 type BlockStmt struct {
 	statements []Stmt
+	size       *int
 }
 
 func (s BlockStmt) Execute(env *Environment) RuntimeException {
-	innerEnv := NewEnvironment(env)
+	innerEnv := NewEnvironment(env, *s.size)
 	for _, stmt := range s.statements {
 		err := stmt.Execute(innerEnv)
 		if err != nil {
@@ -88,6 +112,15 @@ func (s BlockStmt) Resolve(r *Resolver) {
 	for _, stmt := range s.statements {
 		stmt.Resolve(r)
 	}
+	*s.size = r.ScopeSize()
+}
+
+func (s BlockStmt) Compile(c *Compiler) {
+	c.beginScope()
+	for _, stmt := range s.statements {
+		stmt.Compile(c)
+	}
+	c.endScope()
 }
 
 type IfStmt struct {
@@ -119,11 +152,32 @@ func (s IfStmt) Resolve(r *Resolver) {
 	}
 }
 
+func (s IfStmt) Compile(c *Compiler) {
+	s.condition.Compile(c)
+	thenJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	s.thenBranch.Compile(c)
+	elseJump := c.emitJump(OpJump)
+	c.patchJump(thenJump)
+	c.emitOp(OpPop)
+	if s.elseBranch != nil {
+		(*s.elseBranch).Compile(c)
+	}
+	c.patchJump(elseJump)
+}
+
 type WhileStmt struct {
+	label     *Token
 	condition Expr
 	body      Stmt
 }
 
+// Whether a break or continue with the given label (which may be empty)
+// jumps out of the loop with the given label (which may be nil)
+func targetsLoop(jumpLabel string, loopLabel *Token) bool {
+	return jumpLabel == "" || (loopLabel != nil && loopLabel.lexeme == jumpLabel)
+}
+
 func (s WhileStmt) Execute(env *Environment) RuntimeException {
 	for {
 		val, err := s.condition.Evaluate(env)
@@ -137,11 +191,20 @@ func (s WhileStmt) Execute(env *Environment) RuntimeException {
 
 		err = s.body.Execute(env)
 		if err != nil {
-			_, ok := err.(BreakException)
-			if ok {
-				return nil
+			switch e := err.(type) {
+			case BreakException:
+				if targetsLoop(e.label, s.label) {
+					return nil
+				}
+				return err
+			case ContinueException:
+				if targetsLoop(e.label, s.label) {
+					continue
+				}
+				return err
+			default:
+				return err
 			}
-			return err
 		}
 	}
 }
@@ -151,34 +214,327 @@ func (s WhileStmt) Resolve(r *Resolver) {
 	s.body.Resolve(r)
 }
 
-type BreakStmt struct{}
+func (s WhileStmt) Compile(c *Compiler) {
+	c.beginLoop(s.label)
+	loopStart := len(c.chunk().code)
+	s.condition.Compile(c)
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	s.body.Compile(c)
+	c.patchContinues()
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	c.endLoop()
+}
+
+// for loop, minus the initializer, which is declared in a block wrapping
+// the loop. Unlike a while loop, continue still runs the increment.
+type ForStmt struct {
+	label     *Token
+	condition Expr
+	increment *Expr
+	body      Stmt
+}
+
+func (s ForStmt) Execute(env *Environment) RuntimeException {
+	for {
+		val, err := s.condition.Evaluate(env)
+		if err != nil {
+			return err
+		}
+
+		if !val.Bool() {
+			return nil
+		}
+
+		err = s.body.Execute(env)
+		if err != nil {
+			switch e := err.(type) {
+			case BreakException:
+				if targetsLoop(e.label, s.label) {
+					return nil
+				}
+				return err
+			case ContinueException:
+				// Fall through to the increment
+				if !targetsLoop(e.label, s.label) {
+					return err
+				}
+			default:
+				return err
+			}
+		}
+
+		if s.increment != nil {
+			_, err = (*s.increment).Evaluate(env)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s ForStmt) Resolve(r *Resolver) {
+	s.condition.Resolve(r)
+	if s.increment != nil {
+		(*s.increment).Resolve(r)
+	}
+	s.body.Resolve(r)
+}
+
+func (s ForStmt) Compile(c *Compiler) {
+	c.beginLoop(s.label)
+	loopStart := len(c.chunk().code)
+	s.condition.Compile(c)
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	s.body.Compile(c)
+	c.patchContinues()
+	if s.increment != nil {
+		(*s.increment).Compile(c)
+		c.emitOp(OpPop)
+	}
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	c.endLoop()
+}
+
+// for (x in iterable) or for (k, v in iterable) loop. Lists, maps and
+// strings are iterated directly; any other value must have an iter method
+// returning an iterator with a done property and a next method. The
+// variables are declared afresh for each iteration, so closures made in the
+// body capture that iteration's values.
+type ForInStmt struct {
+	label     *Token
+	variables []Token
+	keyword   Token
+	iterable  Expr
+	body      Stmt
+	size      *int
+}
+
+// Returns a token for one of the iterator protocol's properties, placed at
+// the 'in' keyword so that errors point at the loop
+func (s ForInStmt) property(name string) Token {
+	return Token{
+		Position: s.keyword.Position,
+		ty:       TokenTypeIdentifier,
+		lexeme:   name,
+		literal:  nil,
+	}
+}
+
+func (s ForInStmt) getProperty(object Value, name string) (Value, RuntimeException) {
+	inst, ok := object.(Fielder)
+	if !ok {
+		return nil, NewRuntimeError(
+			s.keyword,
+			fmt.Sprintf("%v values have no properties", object.Type()),
+		)
+	}
+	return getProperty(inst, s.property(name))
+}
+
+func (s ForInStmt) iterator(iterable Value) (Value, RuntimeException) {
+	iterator, ok := builtinIterator(iterable, len(s.variables) == 2)
+	if ok {
+		return iterator, nil
+	}
+	if _, ok := iterable.(Fielder); !ok {
+		return nil, notIterableError(s.keyword, iterable)
+	}
+	iter, err := s.getProperty(iterable, "iter")
+	if err != nil {
+		return nil, err
+	}
+	return callValue(iter, []Value{}, s.keyword)
+}
+
+func (s ForInStmt) Execute(env *Environment) RuntimeException {
+	iterable, err := s.iterable.Evaluate(env)
+	if err != nil {
+		return err
+	}
+	iterator, err := s.iterator(iterable)
+	if err != nil {
+		return err
+	}
+
+	for {
+		done, err := s.getProperty(iterator, "done")
+		if err != nil {
+			return err
+		}
+		if done.Bool() {
+			return nil
+		}
+
+		next, err := s.getProperty(iterator, "next")
+		if err != nil {
+			return err
+		}
+		value, err := callValue(next, []Value{}, s.keyword)
+		if err != nil {
+			return err
+		}
+		values := []Value{value}
+		if len(s.variables) == 2 {
+			key, value, err := unpackPair(s.keyword, value)
+			if err != nil {
+				return err
+			}
+			values = []Value{key, value}
+		}
+
+		innerEnv := NewEnvironment(env, *s.size)
+		for i, variable := range s.variables {
+			innerEnv.Define(i, variable, values[i])
+		}
+		err = s.body.Execute(innerEnv)
+		if err != nil {
+			switch e := err.(type) {
+			case BreakException:
+				if targetsLoop(e.label, s.label) {
+					return nil
+				}
+				return err
+			case ContinueException:
+				if !targetsLoop(e.label, s.label) {
+					return err
+				}
+			default:
+				return err
+			}
+		}
+	}
+}
+
+func (s ForInStmt) Resolve(r *Resolver) {
+	s.iterable.Resolve(r)
+
+	r.BeginScope()
+	defer r.EndScope()
+
+	for _, variable := range s.variables {
+		r.Declare(variable)
+		r.Define(variable)
+	}
+	s.body.Resolve(r)
+	*s.size = r.ScopeSize()
+}
+
+// The iterator is kept in a hidden local, and the variables are locals in
+// a scope that is ended (closing any captured ones) after each iteration.
+func (s ForInStmt) Compile(c *Compiler) {
+	c.beginScope()
+	s.iterable.Compile(c)
+	c.setPosition(s.keyword)
+	c.emitOp(OpIter)
+	c.emitByte(byte(len(s.variables)))
+	c.addSyntheticLocal("")
+	iterator := len(c.current.locals) - 1
+
+	c.beginLoop(s.label)
+	loopStart := len(c.chunk().code)
+	c.emitOp(OpGetLocal)
+	c.emitByte(byte(iterator))
+	c.emitOpShort(OpGetProperty, c.identifierConstant("done"))
+	c.emitOp(OpNot)
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+
+	c.beginScope()
+	c.emitOp(OpGetLocal)
+	c.emitByte(byte(iterator))
+	c.emitOpShort(OpGetProperty, c.identifierConstant("next"))
+	c.emitOp(OpCall)
+	c.emitByte(0)
+	if len(s.variables) == 2 {
+		c.emitOp(OpUnpackPair)
+	}
+	for _, variable := range s.variables {
+		c.declareVariable(variable)
+		c.defineVariable(variable)
+	}
+	s.body.Compile(c)
+	c.endScope()
+
+	c.patchContinues()
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	c.endLoop()
+	c.endScope()
+}
+
+type BreakStmt struct {
+	label *Token
+}
 
 func (s BreakStmt) Execute(env *Environment) RuntimeException {
-	return BreakException{}
+	if s.label != nil {
+		return BreakException{label: s.label.lexeme}
+	}
+	return BreakException{label: ""}
 }
 
 func (s BreakStmt) Resolve(r *Resolver) {
 	// No-op
 }
 
+func (s BreakStmt) Compile(c *Compiler) {
+	c.emitBreak(s.label)
+}
+
+type ContinueStmt struct {
+	label *Token
+}
+
+func (s ContinueStmt) Execute(env *Environment) RuntimeException {
+	if s.label != nil {
+		return ContinueException{label: s.label.lexeme}
+	}
+	return ContinueException{label: ""}
+}
+
+func (s ContinueStmt) Resolve(r *Resolver) {
+	// No-op
+}
+
+func (s ContinueStmt) Compile(c *Compiler) {
+	c.emitContinue(s.label)
+}
+
 type FnStmt struct {
 	name     Token
 	function FnExpr
+	slot     *int
 }
 
 func (s FnStmt) Execute(env *Environment) RuntimeException {
 	name := s.name.lexeme
-	fn := NewLoxFn(&name, s.function, env, false, false)
-	env.Define(s.name, fn)
+	fn := NewLoxFn(&name, nil, s.function, env, false, false)
+	env.Define(*s.slot, s.name, fn)
 	return nil
 }
 
 func (s FnStmt) Resolve(r *Resolver) {
-	r.Declare(s.name)
+	*s.slot = r.Declare(s.name)
 	r.Define(s.name)
 	s.function.Resolve(r)
 }
 
+func (s FnStmt) Compile(c *Compiler) {
+	c.declareVariable(s.name)
+	c.markInitialized()
+	name := s.name.lexeme
+	c.function(s.function, &name, nil, FunctionTypeFunction, false)
+	c.setPosition(s.name)
+	c.defineVariable(s.name)
+}
+
 type MethodStmt struct {
 	FnStmt
 	isProperty bool
@@ -192,6 +548,10 @@ func (s MethodStmt) Resolve(r *Resolver) {
 	panic("should not be called")
 }
 
+func (s MethodStmt) Compile(c *Compiler) {
+	panic("should not be called")
+}
+
 type ReturnStmt struct {
 	keyword Token
 	value   *Expr
@@ -222,11 +582,23 @@ func (s ReturnStmt) Resolve(r *Resolver) {
 	}
 }
 
+func (s ReturnStmt) Compile(c *Compiler) {
+	if s.value == nil {
+		c.setPosition(s.keyword)
+		c.emitReturnValue()
+	} else {
+		(*s.value).Compile(c)
+		c.setPosition(s.keyword)
+	}
+	c.emitUnwindingReturn()
+}
+
 type ClassStmt struct {
 	name         Token
 	superclass   *VariableExpr
 	methods      []MethodStmt
 	classMethods []MethodStmt
+	slot         *int
 }
 
 func (s ClassStmt) Execute(env *Environment) RuntimeException {
@@ -246,18 +618,18 @@ func (s ClassStmt) Execute(env *Environment) RuntimeException {
 		supermetaclass = &tmpcls
 	}
 
-	env.Declare(s.name)
+	env.Declare(*s.slot, s.name)
 
 	metaEnv := env
 	if s.superclass != nil {
-		metaEnv = NewEnvironment(env)
-		metaEnv.DefineNative("super", *supermetaclass)
+		metaEnv = NewEnvironment(env, 1)
+		metaEnv.DefineAt(0, *supermetaclass)
 	}
 	metaclass := func(env *Environment) *Class {
-		classMethods := map[string]*LoxFn{}
+		classMethods := map[string]Method{}
 		for _, method := range s.classMethods {
 			name := method.name.lexeme
-			fn := NewLoxFn(&name, method.function, env, false, method.isProperty)
+			fn := NewLoxFn(&name, &s.name.lexeme, method.function, env, false, method.isProperty)
 			classMethods[method.name.lexeme] = fn
 		}
 		return NewClass(nil, s.name.lexeme+" metaclass", supermetaclass, classMethods)
@@ -265,26 +637,26 @@ func (s ClassStmt) Execute(env *Environment) RuntimeException {
 
 	classEnv := env
 	if s.superclass != nil {
-		classEnv = NewEnvironment(env)
-		classEnv.DefineNative("super", *superclass)
+		classEnv = NewEnvironment(env, 1)
+		classEnv.DefineAt(0, *superclass)
 	}
 	class := func(env *Environment) *Class {
-		methods := map[string]*LoxFn{}
+		methods := map[string]Method{}
 		for _, method := range s.methods {
 			name := method.name.lexeme
 			isInit := (name == "init")
-			fn := NewLoxFn(&name, method.function, env, isInit, method.isProperty)
+			fn := NewLoxFn(&name, &s.name.lexeme, method.function, env, isInit, method.isProperty)
 			methods[method.name.lexeme] = fn
 		}
 		return NewClass(&metaclass, s.name.lexeme, superclass, methods)
 	}(classEnv)
 
-	env.Define(s.name, class)
+	env.Define(*s.slot, s.name, class)
 	return nil
 }
 
 func (s ClassStmt) Resolve(r *Resolver) {
-	r.Declare(s.name)
+	*s.slot = r.Declare(s.name)
 	r.Define(s.name)
 
 	if s.superclass != nil {
@@ -317,3 +689,244 @@ func (s ClassStmt) Resolve(r *Resolver) {
 		r.ResolveFunction(method.function, ty)
 	}
 }
+
+func (s ClassStmt) Compile(c *Compiler) {
+	nameConstant := c.identifierConstant(s.name.lexeme)
+	if s.superclass != nil {
+		s.superclass.Compile(c)
+	}
+
+	c.setPosition(s.name)
+	c.declareVariable(s.name)
+	if s.superclass != nil {
+		c.setPosition(s.superclass.name)
+		c.emitOpShort(OpClass, nameConstant)
+		c.emitByte(1)
+	} else {
+		c.setPosition(s.name)
+		c.emitOpShort(OpClass, nameConstant)
+		c.emitByte(0)
+	}
+	c.setPosition(s.name)
+	c.defineVariable(s.name)
+
+	// Methods see super as a local holding the superclass, and class
+	// methods see it as one holding the superclass' metaclass
+	if s.superclass != nil {
+		c.beginScope()
+		defer c.endScope()
+		s.superclass.Compile(c)
+		c.addSyntheticLocal("super")
+	}
+
+	c.emitGetVariable(s.name)
+	for _, method := range s.methods {
+		name := method.name.lexeme
+		ty := FunctionTypeMethod
+		if name == "init" {
+			ty = FunctionTypeInitializer
+		}
+		c.function(method.function, &name, &s.name.lexeme, ty, method.isProperty)
+		c.setPosition(method.name)
+		c.emitOpShort(OpMethod, c.identifierConstant(name))
+	}
+	c.emitOp(OpPop)
+
+	if s.superclass != nil {
+		c.beginScope()
+		defer c.endScope()
+		c.emitGetVariable(s.superclass.name)
+		c.emitOp(OpMetaclass)
+		c.addSyntheticLocal("super")
+	}
+
+	c.emitGetVariable(s.name)
+	for _, method := range s.classMethods {
+		name := method.name.lexeme
+		c.function(method.function, &name, &s.name.lexeme, FunctionTypeMethod, method.isProperty)
+		c.setPosition(method.name)
+		c.emitOpShort(OpClassMethod, c.identifierConstant(name))
+	}
+	c.emitOp(OpPop)
+}
+
+type TryStmt struct {
+	keyword     Token
+	body        BlockStmt
+	catchName   *Token
+	catchBody   *BlockStmt
+	finallyBody *BlockStmt
+}
+
+func (s TryStmt) Execute(env *Environment) RuntimeException {
+	err := s.body.Execute(env)
+	if err != nil && s.catchBody != nil {
+		value, ok := catchableValue(err)
+		if ok {
+			catchEnv := env
+			if s.catchName != nil {
+				catchEnv = NewEnvironment(env, 1)
+				catchEnv.DefineAt(0, value)
+			}
+			err = s.catchBody.Execute(catchEnv)
+		}
+	}
+
+	// The finally block runs no matter how we got here, and anything it
+	// raises (including break and return) takes precedence
+	if s.finallyBody != nil {
+		ferr := s.finallyBody.Execute(env)
+		if ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+func (s TryStmt) Resolve(r *Resolver) {
+	s.body.Resolve(r)
+
+	if s.catchBody != nil {
+		if s.catchName != nil {
+			r.BeginScope()
+			r.Declare(*s.catchName)
+			r.Define(*s.catchName)
+			s.catchBody.Resolve(r)
+			r.EndScope()
+		} else {
+			s.catchBody.Resolve(r)
+		}
+	}
+
+	if s.finallyBody != nil {
+		s.finallyBody.Resolve(r)
+	}
+}
+
+func (s TryStmt) Compile(c *Compiler) {
+	localCount := len(c.current.locals)
+	c.setPosition(s.keyword)
+	handlerOp := OpTry
+	if s.catchBody == nil {
+		handlerOp = OpTryFinally
+	}
+	handlerJump := c.beginTry(handlerOp, s.finallyBody, localCount)
+	s.body.Compile(c)
+	c.endTry()
+	if s.finallyBody != nil {
+		s.finallyBody.Compile(c)
+	}
+	endJumps := []int{c.emitJump(OpJump)}
+
+	// The handler is entered with the exception on top of the stack
+	c.patchJump(handlerJump)
+
+	if s.catchBody == nil {
+		c.compileFinallyAndRethrow(*s.finallyBody, 1)
+	} else {
+		c.beginScope()
+		if s.catchName != nil {
+			c.declareVariable(*s.catchName)
+			c.defineVariable(*s.catchName)
+		} else {
+			c.addSyntheticLocal("")
+		}
+
+		// Errors in the catch block still need to run the finally block
+		rethrowJump := -1
+		if s.finallyBody != nil {
+			rethrowJump = c.beginTry(OpTryFinally, s.finallyBody, localCount)
+		}
+		s.catchBody.Compile(c)
+		if s.finallyBody != nil {
+			c.endTry()
+		}
+		c.endScope()
+
+		if s.finallyBody != nil {
+			s.finallyBody.Compile(c)
+			endJumps = append(endJumps, c.emitJump(OpJump))
+
+			// The caught exception is still on the stack below the new one
+			c.patchJump(rethrowJump)
+			c.compileFinallyAndRethrow(*s.finallyBody, 2)
+		}
+	}
+
+	for _, jump := range endJumps {
+		c.patchJump(jump)
+	}
+}
+
+type ThrowStmt struct {
+	keyword Token
+	value   Expr
+}
+
+func (s ThrowStmt) Execute(env *Environment) RuntimeException {
+	value, err := s.value.Evaluate(env)
+	if err != nil {
+		return err
+	}
+	return NewThrowException(s.keyword, value)
+}
+
+func (s ThrowStmt) Resolve(r *Resolver) {
+	s.value.Resolve(r)
+}
+
+func (s ThrowStmt) Compile(c *Compiler) {
+	s.value.Compile(c)
+	c.setPosition(s.keyword)
+	c.emitOp(OpThrow)
+}
+
+// import "path" as name; or from "path" import name, ...;
+type ImportStmt struct {
+	keyword   Token
+	path      Token
+	names     []Token
+	slots     []int
+	selective bool
+}
+
+func (s ImportStmt) Execute(env *Environment) RuntimeException {
+	module, err := env.importModule(s.keyword, s.path.literal.(string))
+	if err != nil {
+		return err
+	}
+
+	if !s.selective {
+		env.Define(s.slots[0], s.names[0], module)
+		return nil
+	}
+	for i, name := range s.names {
+		value, err := module.Get(name)
+		if err != nil {
+			return err
+		}
+		env.Define(s.slots[i], name, value)
+	}
+	return nil
+}
+
+func (s ImportStmt) Resolve(r *Resolver) {
+	for i, name := range s.names {
+		s.slots[i] = r.Declare(name)
+		r.Define(name)
+	}
+}
+
+func (s ImportStmt) Compile(c *Compiler) {
+	path := c.makeConstant(NewString(s.path.literal.(string)))
+	for _, name := range s.names {
+		c.declareVariable(name)
+		c.setPosition(s.keyword)
+		c.emitOpShort(OpImport, path)
+		if s.selective {
+			c.setPosition(name)
+			c.emitOpShort(OpGetProperty, c.identifierConstant(name.lexeme))
+		}
+		c.defineVariable(name)
+	}
+}
@@ -0,0 +1,227 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestTryStmt(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "throw and catch",
+			source: `
+				try { throw "boom"; } catch (e) { print e; }
+				try { throw [1, 2]; } catch (e) { print e[1]; }
+				try { print 1 / 0; } catch (e) { print e.message; }
+				try {
+					nil.x;
+				} catch (e) {
+					print e.line;
+				}
+				fun fail() { throw "deep"; }
+				fun call() { fail(); print "unreachable"; }
+				try { call(); } catch (e) { print e; }
+			`,
+			output: "boom\n2\ndivision by zero\n6\ndeep\n",
+		},
+		{
+			name: "rethrow",
+			source: `
+				try {
+					try { throw 1; } catch (e) { throw e + 1; }
+				} catch (e) {
+					print e;
+				}
+			`,
+			output: "2\n",
+		},
+		{
+			name: "finally",
+			source: `
+				fun f() { try { return 1; } finally { print "returning"; } }
+				print f();
+				for (var i = 0; i < 3; i = i + 1) {
+					try {
+						if (i == 0) continue;
+						if (i == 2) break;
+						print i;
+					} finally {
+						print "finally ${i}";
+					}
+				}
+				try {
+					try { throw "inner"; } finally { print "cleanup"; }
+				} catch (e) {
+					print e;
+				}
+				try {
+					try { throw "inner"; } catch (_e) { throw "from catch"; } finally { print "cleanup"; }
+				} catch (e) {
+					print e;
+				}
+			`,
+			output: "returning\n1\nfinally 0\n1\nfinally 1\nfinally 2\n" +
+				"cleanup\ninner\ncleanup\nfrom catch\n",
+		},
+		{
+			name:   "uncaught throw",
+			source: `throw "boom";`,
+			err:    "boom",
+		},
+		{
+			name:   "uncaught after finally",
+			source: `try { throw "boom"; } finally { print "finally"; }`,
+			output: "finally\n",
+			err:    "boom",
+		},
+		{
+			name: "errors from calls keep their position through finally",
+			source: `fun fail() { nil.x; }
+fun f() {
+  try {
+    fail();
+  } finally {
+    print "finally";
+  }
+}
+f();`,
+			output: "finally\n",
+			err: "<input>:1:18: runtime error: nil values have no properties\n" +
+				"    fun fail() { nil.x; }\n" +
+				"                     ^\n" +
+				"    in <fn 'fail'> called at <input>:4:10\n" +
+				"    in <fn 'f'> called at <input>:9:3",
+		},
+		{
+			name: "errors from a catch block keep their position through finally",
+			source: `fun fail() { throw "again"; }
+try {
+  throw "first";
+} catch (_e) {
+  fail();
+} finally {
+  print "finally";
+}`,
+			output: "finally\n",
+			err: "<input>:1:14: uncaught exception: again\n" +
+				"    fun fail() { throw \"again\"; }\n" +
+				"                 ^~~~~\n" +
+				"    in <fn 'fail'> called at <input>:5:8",
+		},
+	})
+}
+
+func TestContinueStmt(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "loops",
+			source: `
+				for (var i = 0; i < 5; i = i + 1) {
+					if (i % 2 == 0) continue;
+					print i;
+				}
+				var j = 0;
+				while (j < 5) {
+					j = j + 1;
+					if (j < 4) continue;
+					print j;
+				}
+				for (x in [1, 2, 3]) {
+					if (x == 2) continue;
+					print x;
+				}
+			`,
+			output: "1\n3\n4\n5\n1\n3\n",
+		},
+		{
+			name: "inside blocks and closures",
+			source: `
+				var fns = [];
+				for (var i = 0; i < 3; i = i + 1) {
+					var doubled = i * 2;
+					fun get() { return doubled; }
+					fns.push(get);
+					{
+						var skip = i == 1;
+						if (skip) continue;
+					}
+					print i;
+				}
+				for (f in fns) print f();
+			`,
+			output: "0\n2\n0\n2\n4\n",
+		},
+		{
+			name:   "outside a loop",
+			source: `continue;`,
+			err:    "continue can only be used inside a loop",
+		},
+		{
+			name:   "inside a function in a loop",
+			source: `while (true) { fun f() { continue; } }`,
+			err:    "continue can only be used inside a loop",
+		},
+	})
+}
+
+func TestLabels(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "break and continue",
+			source: `
+				outer: for (var i = 0; i < 3; i = i + 1) {
+					for (var j = 0; j < 3; j = j + 1) {
+						if (j == 1) continue;
+						if (i == 2) break outer;
+						print i * 10 + j;
+					}
+				}
+				var n = 0;
+				rows: while (n < 3) {
+					n = n + 1;
+					for (x in [1, 2]) {
+						if (x == 2) continue rows;
+						print n * 10 + x;
+					}
+					print "unreachable";
+				}
+			`,
+			output: "0\n2\n10\n12\n11\n21\n31\n",
+		},
+		{
+			name: "finally runs when jumping out",
+			source: `
+				outer: for (var i = 0; i < 2; i = i + 1) {
+					while (true) {
+						try {
+							break outer;
+						} finally {
+							print "finally";
+						}
+					}
+				}
+				print "done";
+			`,
+			output: "finally\ndone\n",
+		},
+		{
+			name:   "undefined label",
+			source: `while (true) { break nowhere; }`,
+			err:    "undefined label",
+		},
+		{
+			name:   "label after its loop",
+			source: `done: while (false) {} while (true) { break done; }`,
+			err:    "label can only be used inside its loop",
+		},
+		{
+			name:   "duplicate label",
+			source: `a: while (true) { a: while (true) { break a; } }`,
+			err:    "label is already used by an enclosing loop",
+		},
+		{
+			name:   "label without loop",
+			source: `a: print 1;`,
+			err:    "expected loop after label",
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package lox
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Where a program's input comes from and its output goes to. Shared by the
+// main script and every module it imports, so that redirecting them applies
+// to the whole program, but never shared between interpreters.
+type streams struct {
+	stdout io.Writer
+	stdin  *bufio.Reader
+}
+
+func newStreams() *streams {
+	return &streams{
+		stdout: os.Stdout,
+		stdin:  bufio.NewReader(os.Stdin),
+	}
+}
+
+// Reads the next line of input without its line terminator, or returns
+// nil once the input has run out
+func (s *streams) readLine(args []Value) (Value, RuntimeException) {
+	line, err := s.stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return NewNil(), nil
+		}
+		return nil, NewRuntimeError(Token{}, "read stdin failed: "+err.Error())
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return NewString(line), nil
+}
@@ -0,0 +1,92 @@
+package lox
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetStdout(t *testing.T) {
+	for _, engine := range engines {
+		var first, second bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&first)
+		in.Eval(`print "one";`)
+		in.SetStdout(&second)
+		in.Eval(`print "two";`)
+		if first.String() != "one\n" || second.String() != "two\n" {
+			t.Errorf("%s: got %q and %q", engine.name, first.String(), second.String())
+		}
+	}
+}
+
+func TestSetStdin(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetStdin(strings.NewReader("a\nb\n"))
+		in.Eval(`print readLine() + readLine(); print readLine();`)
+		if got, want := stdout.String(), "ab\nnil\n"; got != want {
+			t.Errorf("%s: got %q, want %q", engine.name, got, want)
+		}
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetStdin(strings.NewReader("windows\r\n\nlast"))
+		in.Eval(`for (var i = 0; i < 4; i = i + 1) print readLine();`)
+		if got, want := stdout.String(), "windows\n\nlast\nnil\n"; got != want {
+			t.Errorf("%s: got %q, want %q", engine.name, got, want)
+		}
+	}
+}
+
+func TestStreamsInModules(t *testing.T) {
+	dir := writeModuleFiles(t, map[string]string{
+		"main.lox": `import "echo.lox" as echo; echo.run();`,
+		"echo.lox": `print "loading"; fun run() { print readLine(); }`,
+	})
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetStdin(strings.NewReader("input\n"))
+		if err := in.RunFile(filepath.Join(dir, "main.lox")); err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		if got, want := stdout.String(), "loading\ninput\n"; got != want {
+			t.Errorf("%s: got %q, want %q", engine.name, got, want)
+		}
+	}
+}
+
+// Interpreters share nothing, so they can run on separate goroutines
+func TestConcurrentInterpreters(t *testing.T) {
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 8)
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := engines[i%len(engines)].new()
+			in.SetStdout(&outputs[i])
+			in.SetStdin(strings.NewReader(fmt.Sprintf("%d\n", i)))
+			in.Eval(`var line = readLine(); for (var j = 0; j < 100; j = j + 1) print line;`)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range outputs {
+		if want := strings.Repeat(fmt.Sprintf("%d\n", i), 100); outputs[i].String() != want {
+			t.Errorf("interpreter %d got %q", i, outputs[i].String())
+		}
+	}
+}
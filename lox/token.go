@@ -2,6 +2,8 @@ package lox
 
 import (
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -11,25 +13,47 @@ const (
 	TokenTypeRightParen
 	TokenTypeLeftBrace
 	TokenTypeRightBrace
+	TokenTypeLeftBracket
+	TokenTypeRightBracket
 	TokenTypeComma
 	TokenTypeDot
+	TokenTypeDotDotDot
 	TokenTypeMinus
+	TokenTypeMinusEqual
+	TokenTypeMinusMinus
 	TokenTypePlus
+	TokenTypePlusEqual
+	TokenTypePlusPlus
 	TokenTypeSemicolon
 	TokenTypeSlash
+	TokenTypeSlashEqual
 	TokenTypeStar
+	TokenTypeStarEqual
+	TokenTypeStarStar
+	TokenTypePercent
+	TokenTypePercentEqual
+	TokenTypeTilde
+	TokenTypeTildeSlash
+	TokenTypeAmpersand
+	TokenTypePipe
+	TokenTypeCaret
 	TokenTypeBang
 	TokenTypeBangEqual
 	TokenTypeEqual
 	TokenTypeEqualEqual
 	TokenTypeGreater
 	TokenTypeGreaterEqual
+	TokenTypeGreaterGreater
 	TokenTypeLess
 	TokenTypeLessEqual
+	TokenTypeLessLess
 	TokenTypeQuestion
 	TokenTypeColon
 	TokenTypeIdentifier
 	TokenTypeString
+	TokenTypeInterpolation
+	TokenTypeInterpolationMid
+	TokenTypeInterpolationEnd
 	TokenTypeNumber
 	TokenTypeAnd
 	TokenTypeClass
@@ -48,63 +72,188 @@ const (
 	TokenTypeVar
 	TokenTypeWhile
 	TokenTypeBreak
+	TokenTypeContinue
+	TokenTypeTry
+	TokenTypeCatch
+	TokenTypeFinally
+	TokenTypeThrow
+	TokenTypeImport
 	TokenTypeEOF
 )
 
 var tokenTypeStringMap = map[TokenType]string{
-	TokenTypeLeftParen:    "LeftParen",
-	TokenTypeRightParen:   "RightParen",
-	TokenTypeLeftBrace:    "LeftBrace",
-	TokenTypeRightBrace:   "RightBrace",
-	TokenTypeComma:        "Comma",
-	TokenTypeDot:          "Dot",
-	TokenTypeMinus:        "Minus",
-	TokenTypePlus:         "Plus",
-	TokenTypeSemicolon:    "Semicolon",
-	TokenTypeSlash:        "Slash",
-	TokenTypeStar:         "Star",
-	TokenTypeBang:         "Bang",
-	TokenTypeBangEqual:    "BangEqual",
-	TokenTypeEqual:        "Equal",
-	TokenTypeEqualEqual:   "EqualEqual",
-	TokenTypeGreater:      "Greater",
-	TokenTypeGreaterEqual: "GreaterEqual",
-	TokenTypeLess:         "Less",
-	TokenTypeLessEqual:    "LessEqual",
-	TokenTypeQuestion:     "Question",
-	TokenTypeColon:        "Colon",
-	TokenTypeIdentifier:   "Identifier",
-	TokenTypeString:       "String",
-	TokenTypeNumber:       "Number",
-	TokenTypeAnd:          "And",
-	TokenTypeClass:        "Class",
-	TokenTypeElse:         "Else",
-	TokenTypeFalse:        "False",
-	TokenTypeFun:          "Fun",
-	TokenTypeFor:          "For",
-	TokenTypeIf:           "If",
-	TokenTypeNil:          "Nil",
-	TokenTypeOr:           "Or",
-	TokenTypePrint:        "Print",
-	TokenTypeReturn:       "Return",
-	TokenTypeSuper:        "Super",
-	TokenTypeThis:         "This",
-	TokenTypeTrue:         "True",
-	TokenTypeVar:          "Var",
-	TokenTypeWhile:        "While",
-	TokenTypeBreak:        "Break",
-	TokenTypeEOF:          "EOF",
+	TokenTypeLeftParen:        "LeftParen",
+	TokenTypeRightParen:       "RightParen",
+	TokenTypeLeftBrace:        "LeftBrace",
+	TokenTypeRightBrace:       "RightBrace",
+	TokenTypeLeftBracket:      "LeftBracket",
+	TokenTypeRightBracket:     "RightBracket",
+	TokenTypeComma:            "Comma",
+	TokenTypeDot:              "Dot",
+	TokenTypeDotDotDot:        "DotDotDot",
+	TokenTypeMinus:            "Minus",
+	TokenTypeMinusEqual:       "MinusEqual",
+	TokenTypeMinusMinus:       "MinusMinus",
+	TokenTypePlus:             "Plus",
+	TokenTypePlusEqual:        "PlusEqual",
+	TokenTypePlusPlus:         "PlusPlus",
+	TokenTypeSemicolon:        "Semicolon",
+	TokenTypeSlash:            "Slash",
+	TokenTypeSlashEqual:       "SlashEqual",
+	TokenTypeStar:             "Star",
+	TokenTypeStarEqual:        "StarEqual",
+	TokenTypeStarStar:         "StarStar",
+	TokenTypePercent:          "Percent",
+	TokenTypePercentEqual:     "PercentEqual",
+	TokenTypeTilde:            "Tilde",
+	TokenTypeTildeSlash:       "TildeSlash",
+	TokenTypeAmpersand:        "Ampersand",
+	TokenTypePipe:             "Pipe",
+	TokenTypeCaret:            "Caret",
+	TokenTypeBang:             "Bang",
+	TokenTypeBangEqual:        "BangEqual",
+	TokenTypeEqual:            "Equal",
+	TokenTypeEqualEqual:       "EqualEqual",
+	TokenTypeGreater:          "Greater",
+	TokenTypeGreaterEqual:     "GreaterEqual",
+	TokenTypeGreaterGreater:   "GreaterGreater",
+	TokenTypeLess:             "Less",
+	TokenTypeLessEqual:        "LessEqual",
+	TokenTypeLessLess:         "LessLess",
+	TokenTypeQuestion:         "Question",
+	TokenTypeColon:            "Colon",
+	TokenTypeIdentifier:       "Identifier",
+	TokenTypeString:           "String",
+	TokenTypeInterpolation:    "Interpolation",
+	TokenTypeInterpolationMid: "InterpolationMid",
+	TokenTypeInterpolationEnd: "InterpolationEnd",
+	TokenTypeNumber:           "Number",
+	TokenTypeAnd:              "And",
+	TokenTypeClass:            "Class",
+	TokenTypeElse:             "Else",
+	TokenTypeFalse:            "False",
+	TokenTypeFun:              "Fun",
+	TokenTypeFor:              "For",
+	TokenTypeIf:               "If",
+	TokenTypeNil:              "Nil",
+	TokenTypeOr:               "Or",
+	TokenTypePrint:            "Print",
+	TokenTypeReturn:           "Return",
+	TokenTypeSuper:            "Super",
+	TokenTypeThis:             "This",
+	TokenTypeTrue:             "True",
+	TokenTypeVar:              "Var",
+	TokenTypeWhile:            "While",
+	TokenTypeBreak:            "Break",
+	TokenTypeContinue:         "Continue",
+	TokenTypeTry:              "Try",
+	TokenTypeCatch:            "Catch",
+	TokenTypeFinally:          "Finally",
+	TokenTypeThrow:            "Throw",
+	TokenTypeImport:           "Import",
+	TokenTypeEOF:              "EOF",
 }
 
 func (ty TokenType) String() string {
 	return tokenTypeStringMap[ty]
 }
 
+// A file (or line of input) being run, which positions point back into
+// so that errors can show the code they are about
+type Source struct {
+	name string
+	text string
+}
+
+func NewSource(name string, text string) *Source {
+	return &Source{
+		name: name,
+		text: text,
+	}
+}
+
+// Where something starts in the source, and how many bytes it spans.
+// Lines and columns start at 1, and columns count characters rather than
+// bytes.
+type Position struct {
+	source *Source
+	offset int
+	line   int
+	column int
+	length int
+}
+
+// Name of the file the position is in, or "" if it is not in a file
+func (p Position) File() string {
+	if p.source == nil {
+		return ""
+	}
+	return p.source.name
+}
+
+func (p Position) Line() int {
+	return p.line
+}
+
+func (p Position) Column() int {
+	return p.column
+}
+
+func (p Position) String() string {
+	if p.source == nil && p.line == 0 {
+		return "<host>"
+	}
+	if p.source == nil {
+		return fmt.Sprintf("line %d", p.line)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.source.name, p.line, p.column)
+}
+
+// Renders the line of source the position is on, underlining the span the
+// position covers (up to the end of the line)
+func (p Position) snippet() string {
+	if p.source == nil {
+		return ""
+	}
+
+	text := p.source.text
+	start := strings.LastIndexByte(text[:p.offset], '\n') + 1
+	end := strings.IndexByte(text[p.offset:], '\n')
+	if end < 0 {
+		end = len(text)
+	} else {
+		end += p.offset
+	}
+	line := strings.TrimRight(text[start:end], "\r")
+
+	// Keep tabs so the underline lines up however they are displayed
+	var pad strings.Builder
+	for _, c := range text[start:p.offset] {
+		if c == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+
+	end = p.offset + p.length
+	if end > start+len(line) {
+		end = start + len(line)
+	}
+	length := utf8.RuneCountInString(text[p.offset:end])
+	underline := "^"
+	if length > 1 {
+		underline += strings.Repeat("~", length-1)
+	}
+
+	return fmt.Sprintf("\n    %s\n    %s%s", line, pad.String(), underline)
+}
+
 type Token struct {
+	Position
 	ty      TokenType
 	lexeme  string
 	literal interface{}
-	line    int
 }
 
 func (t Token) String() string {
@@ -113,10 +262,10 @@ func (t Token) String() string {
 		literalStr = fmt.Sprintf("literal: %#v, ", t.literal)
 	}
 	return fmt.Sprintf(
-		"Token{ty: %v, lexeme: %q, %sline: %d}",
+		"Token{ty: %v, lexeme: %q, %sposition: %v}",
 		t.ty,
 		t.lexeme,
 		literalStr,
-		t.line,
+		t.Position,
 	)
 }
@@ -2,7 +2,10 @@ package lox
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"strconv"
+	"strings"
 )
 
 type Type int
@@ -10,24 +13,164 @@ type Type int
 const (
 	TypeNil Type = iota
 	TypeBool
+	TypeInteger
 	TypeNumber
 	TypeString
 	TypeFn
 	TypeClass
 	TypeInstance
+	TypeList
+	TypeMap
+	TypeModule
+	TypeIterator
+	TypeObject
 )
 
+var typeStringMap = map[Type]string{
+	TypeNil:      "nil",
+	TypeBool:     "bool",
+	TypeInteger:  "integer",
+	TypeNumber:   "float",
+	TypeString:   "string",
+	TypeFn:       "fn",
+	TypeClass:    "class",
+	TypeInstance: "instance",
+	TypeList:     "list",
+	TypeMap:      "map",
+	TypeModule:   "module",
+	TypeIterator: "iterator",
+	TypeObject:   "object",
+}
+
+func (ty Type) String() string {
+	return typeStringMap[ty]
+}
+
+// Values that are Equal must have the same Hash. Hash returns false for
+// values that cannot be used as map keys.
 type Value interface {
 	Type() Type
 	Bool() bool
 	Equal(other Value) bool
+	Hash() (uint64, bool)
 	String() string
 	Repr() string
 }
 
+// splitmix64 finalizer, to spread out hashes of similar values
+func hashUint64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// FNV-1a
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Hash for values compared by identity
+func hashIdentity(x Value) uint64 {
+	return hashUint64(uint64(reflect.ValueOf(x).Pointer()))
+}
+
+// Arity returns the minimum and maximum number of arguments; a maximum
+// of -1 means there is no limit. ParameterNames returns the names that
+// arguments can be passed by, in order, or nil if the callable does not
+// accept named arguments.
 type Callable interface {
 	Value
-	Arity() int
+	Arity() (int, int)
+	ParameterNames() []string
+}
+
+// Returns the error message for calling a callable with the wrong number
+// of arguments, or "" if it accepts that many
+func checkArity(callable Callable, argCount int) string {
+	minArity, maxArity := callable.Arity()
+	if argCount >= minArity && (maxArity < 0 || argCount <= maxArity) {
+		return ""
+	}
+	if minArity == maxArity {
+		return fmt.Sprintf("expected %d argument(s) but got %d", minArity, argCount)
+	} else if maxArity < 0 {
+		return fmt.Sprintf("expected at least %d argument(s) but got %d", minArity, argCount)
+	} else {
+		return fmt.Sprintf(
+			"expected %d to %d argument(s) but got %d",
+			minArity,
+			maxArity,
+			argCount,
+		)
+	}
+}
+
+func missingArgumentMessage(parameter string) string {
+	return fmt.Sprintf("missing argument '%s'", parameter)
+}
+
+// Raised when a call leaves a parameter without a default value unset
+func missingArgumentError(site Token, parameter string) *RuntimeError {
+	return NewRuntimeError(site, missingArgumentMessage(parameter))
+}
+
+// Matches named arguments, which come after the positional ones in args,
+// to the parameters they name. Returns the arguments in parameter order,
+// or an error message if the names don't match. Parameters that were
+// skipped over are left as nil so that they get their default values, or
+// set to nil values for natives, which have no defaults.
+func bindNamedArguments(callable Callable, args []Value, names []string) ([]Value, string) {
+	parameters := callable.ParameterNames()
+	if parameters == nil {
+		return nil, fmt.Sprintf("%s does not accept named arguments", callable.String())
+	}
+
+	positional := len(args) - len(names)
+	bound := make([]Value, positional, len(parameters)+positional)
+	copy(bound, args)
+	for i, name := range names {
+		index := -1
+		for j, parameter := range parameters {
+			if parameter == name {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Sprintf("%s has no parameter named '%s'", callable.String(), name)
+		}
+		for len(bound) <= index {
+			bound = append(bound, nil)
+		}
+		if bound[index] != nil {
+			return nil, fmt.Sprintf("got multiple values for argument '%s'", name)
+		}
+		bound[index] = args[positional+i]
+	}
+
+	minArity, _ := callable.Arity()
+	for i := 0; i < minArity && i < len(parameters); i++ {
+		if i >= len(bound) || bound[i] == nil {
+			return nil, missingArgumentMessage(parameters[i])
+		}
+	}
+
+	if _, ok := callable.(*NativeFn); ok {
+		for i := range bound {
+			if bound[i] == nil {
+				bound[i] = NewNil()
+			}
+		}
+	}
+	return bound, ""
 }
 
 // nil
@@ -51,6 +194,10 @@ func (x Nil) Equal(other Value) bool {
 	return x == other
 }
 
+func (x Nil) Hash() (uint64, bool) {
+	return 0, true
+}
+
 func (x Nil) String() string {
 	return "nil"
 }
@@ -78,6 +225,13 @@ func (x Bool) Equal(other Value) bool {
 	return other.Type() == TypeBool && x.value == other.(Bool).value
 }
 
+func (x Bool) Hash() (uint64, bool) {
+	if x.value {
+		return 1, true
+	}
+	return 2, true
+}
+
 func (x Bool) String() string {
 	return strconv.FormatBool(x.value)
 }
@@ -86,7 +240,7 @@ func (x Bool) Repr() string {
 	return x.String()
 }
 
-// number
+// float
 type Number struct{ value float64 }
 
 func NewNumber(value float64) Number {
@@ -102,11 +256,26 @@ func (x Number) Bool() bool {
 }
 
 func (x Number) Equal(other Value) bool {
-	return other.Type() == TypeNumber && x.value == other.(Number).value
+	order, ok := compareNumbers(x, other)
+	return ok && order == 0
+}
+
+func (x Number) Hash() (uint64, bool) {
+	// -0 == 0, so they need to hash the same
+	if x.value == 0 {
+		return 0, true
+	}
+	return hashUint64(math.Float64bits(x.value)), true
 }
 
+// Floats always have a decimal point, so that they can be told apart from
+// integers
 func (x Number) String() string {
-	return strconv.FormatFloat(x.value, 'f', -1, 64)
+	s := strconv.FormatFloat(x.value, 'f', -1, 64)
+	if !math.IsInf(x.value, 0) && !math.IsNaN(x.value) && !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
 }
 
 func (x Number) Repr() string {
@@ -136,6 +305,10 @@ func (x String) Equal(other Value) bool {
 	return other.Type() == TypeString && x.value == other.(String).value
 }
 
+func (x String) Hash() (uint64, bool) {
+	return hashString(x.value), true
+}
+
 func (x String) String() string {
 	return x.value
 }
@@ -148,19 +321,36 @@ func (x String) Repr() string {
 type NativeFnPtr func(args []Value) (Value, RuntimeException)
 
 type NativeFn struct {
-	arity int
-	name  string
-	fn    NativeFnPtr
+	minArity   int
+	maxArity   int
+	name       string
+	parameters []string
+	fn         NativeFnPtr
 }
 
 func NewNativeFn(arity int, name string, fn NativeFnPtr) *NativeFn {
+	return NewVariadicNativeFn(arity, arity, name, fn)
+}
+
+// Creates a native fn that accepts anywhere from minArity to maxArity
+// arguments, or any number from minArity up if maxArity is -1
+func NewVariadicNativeFn(minArity int, maxArity int, name string, fn NativeFnPtr) *NativeFn {
 	return &NativeFn{
-		arity: arity,
-		name:  name,
-		fn:    fn,
+		minArity:   minArity,
+		maxArity:   maxArity,
+		name:       name,
+		parameters: nil,
+		fn:         fn,
 	}
 }
 
+// Names the parameters of a native fn, so that it can be called with named
+// arguments. Skipped arguments are passed as nil. Returns the fn itself.
+func (x *NativeFn) WithParameterNames(names ...string) *NativeFn {
+	x.parameters = names
+	return x
+}
+
 func (x *NativeFn) Type() Type {
 	return TypeFn
 }
@@ -173,16 +363,28 @@ func (x *NativeFn) Equal(other Value) bool {
 	return x == other
 }
 
+func (x *NativeFn) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
 func (x *NativeFn) String() string {
-	return fmt.Sprintf("<native fn '%s'>", x.name)
+	if x.name != "" {
+		return fmt.Sprintf("<native fn '%s'>", x.name)
+	} else {
+		return "<anonymous native fn>"
+	}
 }
 
 func (x *NativeFn) Repr() string {
 	return x.String()
 }
 
-func (x *NativeFn) Arity() int {
-	return x.arity
+func (x *NativeFn) Arity() (int, int) {
+	return x.minArity, x.maxArity
+}
+
+func (x *NativeFn) ParameterNames() []string {
+	return x.parameters
 }
 
 func (x *NativeFn) Fn() NativeFnPtr {
@@ -192,6 +394,7 @@ func (x *NativeFn) Fn() NativeFnPtr {
 // lox fn
 type LoxFn struct {
 	name        *string
+	class       *string
 	declaration FnExpr
 	env         *Environment
 	isInit      bool
@@ -200,6 +403,7 @@ type LoxFn struct {
 
 func NewLoxFn(
 	name *string,
+	class *string,
 	declaration FnExpr,
 	env *Environment,
 	isInit bool,
@@ -207,6 +411,7 @@ func NewLoxFn(
 ) *LoxFn {
 	return &LoxFn{
 		name:        name,
+		class:       class,
 		declaration: declaration,
 		env:         env,
 		isInit:      isInit,
@@ -226,6 +431,10 @@ func (x *LoxFn) Equal(other Value) bool {
 	return x == other
 }
 
+func (x *LoxFn) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
 func (x *LoxFn) String() string {
 	if x.name != nil {
 		return fmt.Sprintf("<fn '%s'>", *x.name)
@@ -238,8 +447,12 @@ func (x *LoxFn) Repr() string {
 	return x.String()
 }
 
-func (x *LoxFn) Arity() int {
-	return len(x.declaration.parameters)
+func (x *LoxFn) Arity() (int, int) {
+	return x.declaration.arity()
+}
+
+func (x *LoxFn) ParameterNames() []string {
+	return x.declaration.parameterNames()
 }
 
 func (x *LoxFn) FnWithEnv() (FnExpr, *Environment) {
@@ -254,10 +467,31 @@ func (x *LoxFn) IsProperty() bool {
 	return x.isProperty
 }
 
+func (x *LoxFn) Bind(instance *Instance) Method {
+	env := NewEnvironment(x.env, 1)
+	env.DefineAt(0, instance)
+	return NewLoxFn(x.name, x.class, x.declaration, env, x.isInit, x.isProperty)
+}
+
+// common interface for functions that can be used as methods, so that
+// classes can be shared between the interpreter and the VM
+type Method interface {
+	Callable
+	Bind(instance *Instance) Method
+	IsInit() bool
+	IsProperty() bool
+}
+
 // common interface for classes and instances
 type Fielder interface {
 	Get(name Token) (Value, RuntimeException)
-	Set(name Token, value Value)
+	Set(name Token, value Value) RuntimeException
+}
+
+// common interface for values that support subscripts
+type Indexer interface {
+	GetIndex(bracket Token, index Value) (Value, RuntimeException)
+	SetIndex(bracket Token, index Value, value Value) RuntimeException
 }
 
 // class
@@ -265,14 +499,14 @@ type Class struct {
 	Instance
 	name       string
 	superclass **Class
-	methods    map[string]*LoxFn
+	methods    map[string]Method
 }
 
 func NewClass(
 	metaclass **Class,
 	name string,
 	superclass **Class,
-	methods map[string]*LoxFn,
+	methods map[string]Method,
 ) *Class {
 	return &Class{
 		Instance: Instance{
@@ -297,6 +531,10 @@ func (x *Class) Equal(other Value) bool {
 	return x == other
 }
 
+func (x *Class) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
 func (x *Class) String() string {
 	return fmt.Sprintf("<class '%s'>", x.name)
 }
@@ -305,10 +543,10 @@ func (x *Class) Repr() string {
 	return x.String()
 }
 
-func (x *Class) method(name string) **LoxFn {
+func (x *Class) method(name string) Method {
 	method, ok := x.methods[name]
 	if ok {
-		return &method
+		return method
 	}
 	if x.superclass != nil {
 		return (*x.superclass).method(name)
@@ -316,16 +554,24 @@ func (x *Class) method(name string) **LoxFn {
 	return nil
 }
 
-func (x *Class) initializer() **LoxFn {
+func (x *Class) initializer() Method {
 	return x.method("init")
 }
 
-func (x *Class) Arity() int {
+func (x *Class) Arity() (int, int) {
+	initializer := x.initializer()
+	if initializer != nil {
+		return initializer.Arity()
+	}
+	return 0, 0
+}
+
+func (x *Class) ParameterNames() []string {
 	initializer := x.initializer()
 	if initializer != nil {
-		return (*initializer).Arity()
+		return initializer.ParameterNames()
 	}
-	return 0
+	return []string{}
 }
 
 // class instance
@@ -353,6 +599,10 @@ func (x *Instance) Equal(other Value) bool {
 	return x == other
 }
 
+func (x *Instance) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
 func (x *Instance) String() string {
 	return fmt.Sprintf("<instance of class '%s'>", x.Class().name)
 }
@@ -365,30 +615,23 @@ func (x *Instance) Class() *Class {
 	return *x.class
 }
 
-func (x *Instance) bind(method *LoxFn) *LoxFn {
-	env := NewEnvironment(method.env)
-	env.DefineNative("this", x)
-	return NewLoxFn(method.name, method.declaration, env, method.isInit, method.isProperty)
-}
-
-func (x *Instance) Initializer() **LoxFn {
+func (x *Instance) Initializer() Method {
 	init := x.Class().initializer()
 	if init == nil {
 		return nil
 	}
-	tmp := x.bind(*init)
-	return &tmp
+	return init.Bind(x)
 }
 
-func (x *Instance) MethodAtClass(name Token, class *Class) (*LoxFn, RuntimeException) {
+func (x *Instance) MethodAtClass(name Token, class *Class) (Method, RuntimeException) {
 	method := class.method(name.lexeme)
 	if method != nil {
-		return x.bind(*method), nil
+		return method.Bind(x), nil
 	}
 
 	return nil, NewRuntimeError(
 		name,
-		fmt.Sprintf("undefined method '%s'", class.name, name.lexeme),
+		fmt.Sprintf("undefined method '%s' in class '%s'", name.lexeme, class.name),
 	)
 }
 
@@ -400,7 +643,7 @@ func (x *Instance) Get(name Token) (Value, RuntimeException) {
 
 	method := x.Class().method(name.lexeme)
 	if method != nil {
-		return x.bind(*method), nil
+		return method.Bind(x), nil
 	}
 
 	return nil, NewRuntimeError(
@@ -409,6 +652,7 @@ func (x *Instance) Get(name Token) (Value, RuntimeException) {
 	)
 }
 
-func (x *Instance) Set(name Token, value Value) {
+func (x *Instance) Set(name Token, value Value) RuntimeException {
 	x.fields[name.lexeme] = value
+	return nil
 }
@@ -0,0 +1,196 @@
+package lox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRestParameters(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "rest",
+			source: `
+				fun f(a, ...rest) { return [a, rest]; }
+				print f(1);
+				print f(1, 2, 3);
+				fun all(...xs) { return xs.len(); }
+				print all();
+				print all(nil, nil);
+				class A { m(...xs) { return xs; } }
+				print A().m(1, 2);
+				var g = fun (...xs) { return xs; };
+				print g("x");
+			`,
+			output: "[1, []]\n[1, [2, 3]]\n0\n2\n[1, 2]\n[\"x\"]\n",
+		},
+		{
+			name:   "too few arguments",
+			source: `fun f(_a, _b, ..._rest) {} f(1);`,
+			err:    "expected at least 2 argument(s) but got 1",
+		},
+		{
+			name:   "rest must be last",
+			source: `fun f(...rest, a) {}`,
+			err:    "rest parameter must be the last parameter",
+		},
+	})
+}
+
+func TestVariadicNativeFn(t *testing.T) {
+	count := func(args []Value) (Value, RuntimeException) {
+		return NewInteger(int64(len(args))), nil
+	}
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetGlobal("atLeastOne", NewVariadicNativeFn(1, -1, "atLeastOne", count))
+		in.SetGlobal("oneToThree", NewVariadicNativeFn(1, 3, "oneToThree", count))
+		in.SetGlobal("two", NewNativeFn(2, "two", count))
+
+		_, err := in.Eval(`
+			print atLeastOne(1, 2, 3, 4);
+			print oneToThree(1, 2, 3);
+			try { atLeastOne(); } catch (e) { print e.message; }
+			try { oneToThree(1, 2, 3, 4); } catch (e) { print e.message; }
+			try { two(1); } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "4\n3\nexpected at least 1 argument(s) but got 0\n" +
+			"expected 1 to 3 argument(s) but got 4\nexpected 2 argument(s) but got 1\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+
+		fn, _ := in.GetGlobal("oneToThree")
+		if minArity, maxArity := fn.(*NativeFn).Arity(); minArity != 1 || maxArity != 3 {
+			t.Errorf("%s: got arity %d to %d", engine.name, minArity, maxArity)
+		}
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "defaults",
+			source: `
+				fun f(a, b = 2, ...rest) { return [a, b, rest]; }
+				print f(1);
+				print f(1, 5, 6, 7);
+				print f(1, nil);
+			`,
+			output: "[1, 2, []]\n[1, 5, [6, 7]]\n[1, nil, []]\n",
+		},
+		{
+			name: "evaluated at call time",
+			source: `
+				var calls = 0;
+				fun next() { calls = calls + 1; return calls; }
+				fun f(x = next(), xs = []) { xs.push(x); return xs; }
+				print f();
+				print f();
+				print f(10);
+				print calls;
+				var scale = 1;
+				fun g(x = scale) { return x; }
+				scale = 2;
+				print g();
+			`,
+			output: "[1]\n[2]\n[10]\n2\n2\n",
+		},
+		{
+			name: "methods and initializers",
+			source: `
+				class Point {
+					init(x = 0, y = 0) { this.x = x; this.y = y; }
+					moved(dx = 1) { return Point(this.x + dx); }
+				}
+				var p = Point();
+				print p.x;
+				print p.moved().x;
+				print Point(3).moved(4).x;
+			`,
+			output: "0\n1\n7\n",
+		},
+		{
+			name:   "default before required",
+			source: `fun f(_a = 1, _b) {}`,
+			err:    "parameter without a default value follows one with a default value",
+		},
+		{
+			name:   "too many arguments",
+			source: `fun f(_a, _b = 1) {} f(1, 2, 3);`,
+			err:    "expected 1 to 2 argument(s) but got 3",
+		},
+	})
+}
+
+func TestNamedArguments(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{
+			name: "named",
+			source: `
+				fun f(a, b = 2, c = 3) { return [a, b, c]; }
+				print f(b: 3, a: 4);
+				print f(1, c: 5);
+				print f(a: 1);
+				class A { init(x, y = "y") { this.s = x + y; } }
+				print A(y: "!", x: "hi").s;
+			`,
+			output: "[4, 3, 3]\n[1, 2, 5]\n[1, 2, 3]\nhi!\n",
+		},
+		{
+			name: "errors",
+			source: `
+				fun f(a, b = 2) { return [a, b]; }
+				try { f(b: 1); } catch (e) { print e.message; }
+				try { f(1, a: 2); } catch (e) { print e.message; }
+				try { f(1, c: 2); } catch (e) { print e.message; }
+				try { readLine(x: 1); } catch (e) { print e.message; }
+			`,
+			output: "missing argument 'a'\ngot multiple values for argument 'a'\n" +
+				"<fn 'f'> has no parameter named 'c'\n<native fn 'readLine'> does not accept named arguments\n",
+		},
+		{
+			name: "missing argument",
+			source: `
+				fun f(a, b) { return a + b; }
+				f(1);
+			`,
+			err: "expected 2 argument(s) but got 1",
+		},
+		{
+			name:   "positional after named",
+			source: `fun f(_a, _b) {} f(a: 1, 2);`,
+			err:    "positional argument follows named argument",
+		},
+	})
+}
+
+func TestNativeFnWithParameterNames(t *testing.T) {
+	for _, engine := range engines {
+		var stdout bytes.Buffer
+		in := engine.new()
+		in.SetStdout(&stdout)
+		in.SetGlobal("pad", NewVariadicNativeFn(1, 3, "pad", func(args []Value) (Value, RuntimeException) {
+			return NewList(append([]Value{}, args...)), nil
+		}).WithParameterNames("text", "width", "fill"))
+
+		_, err := in.Eval(`
+			print pad("a", fill: "*");
+			print pad(width: 3, text: "b");
+			try { pad(width: 3); } catch (e) { print e.message; }
+			try { pad("a", size: 3); } catch (e) { print e.message; }
+		`)
+		if err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		want := "[\"a\", nil, \"*\"]\n[\"b\", 3]\nmissing argument 'text'\n" +
+			"<native fn 'pad'> has no parameter named 'size'\n"
+		if stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", engine.name, stdout.String(), want)
+		}
+	}
+}
@@ -0,0 +1,1102 @@
+package lox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// How deeply calls may be nested unless the interpreter says otherwise.
+	// This leaves the tree-walking interpreter some of the Go stack to spare
+	// with typical functions; ones that use much more of the Go stack per
+	// call can still exhaust it first.
+	defaultMaxCallDepth = 100000
+	// The stack starts out this big and doubles whenever it fills up
+	vmStackInitial = 256
+)
+
+// compiled fn, as produced by the compiler. Only exists at runtime
+// wrapped in a closure, but is also stored in the constant table.
+type FnProto struct {
+	name         *string
+	class        *string
+	arity        int
+	minArity     int
+	variadic     bool
+	parameters   []string
+	upvalueCount int
+	chunk        *Chunk
+	isInit       bool
+	isProperty   bool
+	isScript     bool
+}
+
+func newFnProto(name *string, class *string, ty FunctionType, isProperty bool) *FnProto {
+	return &FnProto{
+		name:         name,
+		class:        class,
+		arity:        0,
+		minArity:     0,
+		variadic:     false,
+		parameters:   nil,
+		upvalueCount: 0,
+		chunk:        NewChunk(),
+		isInit:       ty == FunctionTypeInitializer,
+		isProperty:   isProperty,
+		isScript:     ty == FunctionTypeNone,
+	}
+}
+
+func (x *FnProto) Type() Type {
+	return TypeFn
+}
+
+func (x *FnProto) Bool() bool {
+	return true
+}
+
+func (x *FnProto) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *FnProto) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *FnProto) String() string {
+	if x.name != nil {
+		return fmt.Sprintf("<fn '%s'>", *x.name)
+	} else {
+		return "<anonymous fn>"
+	}
+}
+
+func (x *FnProto) Repr() string {
+	return x.String()
+}
+
+func (x *FnProto) Disassemble() string {
+	return x.chunk.Disassemble(x.String())
+}
+
+// captured variable; points into the VM stack while the variable is
+// still live, and at its own storage once it goes out of scope
+type Upvalue struct {
+	location *Value
+	closed   Value
+	slot     int
+	next     *Upvalue
+}
+
+// vm fn
+type Closure struct {
+	proto    *FnProto
+	upvalues []*Upvalue
+	module   *vmModule
+}
+
+func NewClosure(proto *FnProto) *Closure {
+	return &Closure{
+		proto:    proto,
+		upvalues: make([]*Upvalue, proto.upvalueCount),
+		module:   nil,
+	}
+}
+
+func (x *Closure) Type() Type {
+	return TypeFn
+}
+
+func (x *Closure) Bool() bool {
+	return true
+}
+
+func (x *Closure) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *Closure) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *Closure) String() string {
+	return x.proto.String()
+}
+
+func (x *Closure) Repr() string {
+	return x.String()
+}
+
+func (x *Closure) Arity() (int, int) {
+	if x.proto.variadic {
+		return x.proto.minArity, -1
+	}
+	return x.proto.minArity, x.proto.arity
+}
+
+func (x *Closure) ParameterNames() []string {
+	return x.proto.parameters
+}
+
+func (x *Closure) Bind(instance *Instance) Method {
+	return &BoundMethod{
+		receiver: instance,
+		method:   x,
+	}
+}
+
+func (x *Closure) IsInit() bool {
+	return x.proto.isInit
+}
+
+func (x *Closure) IsProperty() bool {
+	return x.proto.isProperty
+}
+
+// vm fn bound to an instance
+type BoundMethod struct {
+	receiver *Instance
+	method   *Closure
+}
+
+func (x *BoundMethod) Type() Type {
+	return TypeFn
+}
+
+func (x *BoundMethod) Bool() bool {
+	return true
+}
+
+func (x *BoundMethod) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *BoundMethod) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *BoundMethod) String() string {
+	return x.method.String()
+}
+
+func (x *BoundMethod) Repr() string {
+	return x.String()
+}
+
+func (x *BoundMethod) Arity() (int, int) {
+	return x.method.Arity()
+}
+
+func (x *BoundMethod) ParameterNames() []string {
+	return x.method.ParameterNames()
+}
+
+func (x *BoundMethod) Bind(instance *Instance) Method {
+	return x.method.Bind(instance)
+}
+
+func (x *BoundMethod) IsInit() bool {
+	return x.method.IsInit()
+}
+
+func (x *BoundMethod) IsProperty() bool {
+	return x.method.IsProperty()
+}
+
+type callFrame struct {
+	closure *Closure
+	ip      int
+	slots   int
+}
+
+// The globals of the module a closure was created in, and the directory
+// that the module's imports are relative to
+type vmModule struct {
+	globals map[string]Value
+	dir     string
+}
+
+// Where to resume execution when an exception is thrown inside a try block
+type vmHandler struct {
+	frame    int
+	ip       int
+	stackTop int
+	rethrows bool
+}
+
+// An exception caught by a handler that only runs a finally block. It is
+// kept on the stack, where Lox code cannot see it, so that OpRethrow can
+// raise it again with its original position and stack trace.
+type vmException struct {
+	err RuntimeException
+}
+
+func (x *vmException) Type() Type {
+	return TypeObject
+}
+
+func (x *vmException) Bool() bool {
+	return true
+}
+
+func (x *vmException) Equal(other Value) bool {
+	return x == other
+}
+
+func (x *vmException) Hash() (uint64, bool) {
+	return hashIdentity(x), true
+}
+
+func (x *vmException) String() string {
+	return "<exception>"
+}
+
+func (x *vmException) Repr() string {
+	return x.String()
+}
+
+// Stack-based bytecode virtual machine. Globals persist across calls to
+// Interpret, so a single VM can be reused for each line of a REPL.
+type VM struct {
+	frames       []*callFrame
+	stack        []Value
+	stackTop     int
+	handlers     []vmHandler
+	main         *vmModule
+	modules      *moduleLoader
+	openUpvalues *Upvalue
+	streams      *streams
+	maxCallDepth int
+}
+
+func NewVM() *VM {
+	return &VM{
+		frames:       []*callFrame{},
+		stack:        make([]Value, vmStackInitial),
+		stackTop:     0,
+		handlers:     []vmHandler{},
+		main:         &vmModule{globals: map[string]Value{}, dir: ""},
+		modules:      newModuleLoader(),
+		openUpvalues: nil,
+		streams:      newStreams(),
+		maxCallDepth: defaultMaxCallDepth,
+	}
+}
+
+func (vm *VM) DefineNative(name string, value Value) {
+	vm.main.globals[name] = value
+	vm.modules.natives[name] = value
+}
+
+// Sets the path of the script being run, which imports are relative to.
+func (vm *VM) SetScriptPath(path string) {
+	vm.main.dir = filepath.Dir(path)
+	vm.modules.setMain(path)
+}
+
+func (vm *VM) Interpret(proto *FnProto) (Value, RuntimeException) {
+	closure := NewClosure(proto)
+	closure.module = vm.main
+	vm.push(closure)
+	err := vm.call(closure, 0)
+	if err != nil {
+		vm.reset()
+		return nil, err
+	}
+
+	result, err := vm.run()
+	if err != nil {
+		vm.traceFrames(err, 0, true)
+		vm.reset()
+		return nil, err
+	}
+	return result, nil
+}
+
+// Calls a value from Go code, which may itself have been called by code
+// running in the VM (e.g. by a native).
+func (vm *VM) Call(callee Value, args []Value) (Value, RuntimeException) {
+	frameCount := len(vm.frames)
+	stackTop := vm.stackTop
+	handlerCount := len(vm.handlers)
+
+	vm.push(callee)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	err := vm.callValue(callee, len(args))
+	var result Value
+	if err == nil {
+		if len(vm.frames) > frameCount {
+			result, err = vm.run()
+		} else {
+			result = vm.pop()
+		}
+	}
+
+	if err != nil {
+		// Code further up the stack will add the rest of the trace
+		vm.traceFrames(err, frameCount, true)
+		vm.unwind(frameCount, stackTop, handlerCount)
+		return nil, err
+	}
+	return result, nil
+}
+
+// Abandons everything pushed since the VM had the given number of frames,
+// stack slots and exception handlers
+func (vm *VM) unwind(frameCount int, stackTop int, handlerCount int) {
+	vm.closeUpvalues(stackTop)
+	for vm.stackTop > stackTop {
+		vm.pop()
+	}
+	vm.frames = vm.frames[:frameCount]
+	vm.handlers = vm.handlers[:handlerCount]
+}
+
+// Adds the calls that were in progress when an uncaught exception was
+// raised to its stack trace, down to the given frame, which may have been
+// called from Go. Scripts are left out, since they are only "called" to run
+// the top level of a program or module.
+func (vm *VM) traceFrames(err RuntimeException, from int, calledFromGo bool) {
+	for i := len(vm.frames) - 1; i >= from; i-- {
+		proto := vm.frames[i].closure.proto
+		if proto.isScript {
+			continue
+		}
+		site := Position{}
+		if i > from || !calledFromGo {
+			caller := vm.frames[i-1]
+			site = caller.closure.proto.chunk.positions[caller.ip-1]
+		}
+		addStackFrame(err, describeFunction(proto.String(), proto.class), site)
+	}
+}
+
+func (vm *VM) reset() {
+	for i := range vm.stack[:vm.stackTop] {
+		vm.stack[i] = nil
+	}
+	vm.stackTop = 0
+	vm.frames = vm.frames[:0]
+	vm.handlers = vm.handlers[:0]
+	vm.openUpvalues = nil
+}
+
+func (vm *VM) push(value Value) {
+	if vm.stackTop == len(vm.stack) {
+		vm.growStack()
+	}
+	vm.stack[vm.stackTop] = value
+	vm.stackTop++
+}
+
+// Doubles the size of the stack, pointing any open upvalues at the new
+// copies of their variables
+func (vm *VM) growStack() {
+	stack := make([]Value, 2*len(vm.stack))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	for upvalue := vm.openUpvalues; upvalue != nil; upvalue = upvalue.next {
+		upvalue.location = &vm.stack[upvalue.slot]
+	}
+}
+
+func (vm *VM) pop() Value {
+	vm.stackTop--
+	value := vm.stack[vm.stackTop]
+	vm.stack[vm.stackTop] = nil
+	return value
+}
+
+func (vm *VM) peek(distance int) Value {
+	return vm.stack[vm.stackTop-1-distance]
+}
+
+// Builds a token for errors raised by the VM, since the bytecode only
+// retains positions and not the original tokens.
+func (vm *VM) token(lexeme string) Token {
+	position := Position{}
+	if len(vm.frames) > 0 {
+		frame := vm.frames[len(vm.frames)-1]
+		position = frame.closure.proto.chunk.positions[frame.ip-1]
+	}
+	return Token{
+		Position: position,
+		ty:       TokenTypeIdentifier,
+		lexeme:   lexeme,
+		literal:  nil,
+	}
+}
+
+func (vm *VM) runtimeError(message string) *RuntimeError {
+	return NewRuntimeError(vm.token(""), message)
+}
+
+func (vm *VM) call(closure *Closure, argCount int) RuntimeException {
+	// The first frame is the script's, so this is how deeply calls are
+	// already nested
+	if len(vm.frames) > vm.maxCallDepth {
+		return vm.runtimeError("stack overflow")
+	}
+
+	// Leave the slots of missing arguments uninitialized, so that the
+	// function fills in their default values, and collect the extra
+	// arguments into a list for the rest parameter
+	for i := 0; i < closure.proto.minArity && i < argCount; i++ {
+		if vm.stack[vm.stackTop-argCount+i] == nil {
+			return missingArgumentError(vm.token(""), closure.proto.parameters[i])
+		}
+	}
+	fixed := closure.proto.arity
+	if closure.proto.variadic {
+		fixed--
+	}
+	for argCount < fixed {
+		vm.push(nil)
+		argCount++
+	}
+	if closure.proto.variadic {
+		rest := make([]Value, argCount-fixed)
+		copy(rest, vm.stack[vm.stackTop-len(rest):vm.stackTop])
+		for range rest {
+			vm.pop()
+		}
+		vm.push(NewList(rest))
+		argCount = fixed + 1
+	}
+
+	vm.pushFrame(closure, vm.stackTop-argCount-1)
+	return nil
+}
+
+// Pushes a call frame, reusing one from an earlier call if possible. Frames
+// are kept behind pointers so that the one run is executing stays put when
+// the slice of them grows.
+func (vm *VM) pushFrame(closure *Closure, slots int) {
+	n := len(vm.frames)
+	if n < cap(vm.frames) && vm.frames[:n+1][n] != nil {
+		vm.frames = vm.frames[:n+1]
+	} else {
+		vm.frames = append(vm.frames, &callFrame{})
+	}
+	*vm.frames[n] = callFrame{
+		closure: closure,
+		ip:      0,
+		slots:   slots,
+	}
+}
+
+func (vm *VM) callValue(callee Value, argCount int) RuntimeException {
+	callable, ok := callee.(Callable)
+	if !ok {
+		return vm.runtimeError("value is not callable")
+	}
+
+	message := checkArity(callable, argCount)
+	if message != "" {
+		return vm.runtimeError(message)
+	}
+
+	switch callable := callable.(type) {
+	case *NativeFn:
+		args := make([]Value, argCount)
+		copy(args, vm.stack[vm.stackTop-argCount:vm.stackTop])
+		result, err := callable.Fn()(args)
+		if err != nil {
+			site := vm.token("")
+			return addStackFrame(locateError(err, site), callable.String(), site.Position)
+		}
+		vm.stackTop -= argCount + 1
+		vm.push(result)
+		return nil
+	case *Closure:
+		return vm.call(callable, argCount)
+	case *BoundMethod:
+		vm.stack[vm.stackTop-argCount-1] = callable.receiver
+		return vm.call(callable.method, argCount)
+	case *Class:
+		instance := NewInstance(callable)
+		vm.stack[vm.stackTop-argCount-1] = instance
+		initializer := callable.initializer()
+		if initializer != nil {
+			return vm.call(initializer.(*Closure), argCount)
+		}
+		return nil
+	default:
+		return vm.runtimeError("value is not callable")
+	}
+}
+
+// Calls the callee below the top argCount values, the last len(names) of
+// which are named arguments
+func (vm *VM) callNamed(argCount int, names *List) RuntimeException {
+	callable, ok := vm.peek(argCount).(Callable)
+	if !ok {
+		return vm.runtimeError("value is not callable")
+	}
+
+	args := make([]Value, argCount)
+	copy(args, vm.stack[vm.stackTop-argCount:vm.stackTop])
+	nameStrings := make([]string, len(names.elements))
+	for i, name := range names.elements {
+		nameStrings[i] = name.(String).value
+	}
+	args, message := bindNamedArguments(callable, args, nameStrings)
+	if message != "" {
+		return vm.runtimeError(message)
+	}
+
+	for i := 0; i < argCount; i++ {
+		vm.pop()
+	}
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	return vm.callValue(callable, len(args))
+}
+
+// Looks up a property, calling it if it turns out to be a property method.
+// Returns whether a new call frame was pushed.
+func (vm *VM) pushProperty(value Value) (bool, RuntimeException) {
+	vm.push(value)
+	method, ok := value.(Method)
+	if !ok || !method.IsProperty() {
+		return false, nil
+	}
+	err := vm.callValue(value, 0)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	var prev *Upvalue = nil
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		prev = upvalue
+		upvalue = upvalue.next
+	}
+
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+
+	created := &Upvalue{
+		location: &vm.stack[slot],
+		closed:   nil,
+		slot:     slot,
+		next:     upvalue,
+	}
+
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.next = created
+	}
+	return created
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = *upvalue.location
+		upvalue.location = &upvalue.closed
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+// The operator each arithmetic op applies, so that the VM can share the
+// interpreter's implementation of them
+var vmOperators = [...]Token{
+	OpAdd:          {ty: TokenTypePlus, lexeme: "+"},
+	OpSubtract:     {ty: TokenTypeMinus, lexeme: "-"},
+	OpMultiply:     {ty: TokenTypeStar, lexeme: "*"},
+	OpDivide:       {ty: TokenTypeSlash, lexeme: "/"},
+	OpModulo:       {ty: TokenTypePercent, lexeme: "%"},
+	OpFloorDivide:  {ty: TokenTypeTildeSlash, lexeme: "~/"},
+	OpPower:        {ty: TokenTypeStarStar, lexeme: "**"},
+	OpBitAnd:       {ty: TokenTypeAmpersand, lexeme: "&"},
+	OpBitOr:        {ty: TokenTypePipe, lexeme: "|"},
+	OpBitXor:       {ty: TokenTypeCaret, lexeme: "^"},
+	OpShiftLeft:    {ty: TokenTypeLessLess, lexeme: "<<"},
+	OpShiftRight:   {ty: TokenTypeGreaterGreater, lexeme: ">>"},
+	OpGreater:      {ty: TokenTypeGreater, lexeme: ">"},
+	OpGreaterEqual: {ty: TokenTypeGreaterEqual, lexeme: ">="},
+	OpLess:         {ty: TokenTypeLess, lexeme: "<"},
+	OpLessEqual:    {ty: TokenTypeLessEqual, lexeme: "<="},
+	OpNegate:       {ty: TokenTypeMinus, lexeme: "-"},
+	OpBitNot:       {ty: TokenTypeTilde, lexeme: "~"},
+}
+
+// Compiles and runs a module to completion on top of whatever is currently
+// executing.
+func (vm *VM) runModule(stmts []Stmt, globals map[string]Value, dir string) RuntimeException {
+	proto, errs := NewCompiler().CompileStatements(stmts)
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return vm.runtimeError(strings.Join(messages, "\n"))
+	}
+
+	closure := NewClosure(proto)
+	closure.module = &vmModule{globals: globals, dir: dir}
+	vm.push(closure)
+	err := vm.call(closure, 0)
+	if err != nil {
+		return err
+	}
+	_, err = vm.run()
+	return err
+}
+
+// Runs until the frame that was on top of the call stack when run was
+// called returns, and returns its result.
+func (vm *VM) run() (Value, RuntimeException) {
+	baseFrame := len(vm.frames) - 1
+	for {
+		result, err := vm.execute(baseFrame)
+		if err == nil {
+			return result, nil
+		}
+		if !vm.catch(err, baseFrame) {
+			return nil, err
+		}
+	}
+}
+
+// Unwinds to the innermost handler and pushes the caught value for it, or
+// the exception itself if the handler rethrows it. Returns false if the
+// exception cannot be caught.
+func (vm *VM) catch(err RuntimeException, baseFrame int) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+	handler := vm.handlers[len(vm.handlers)-1]
+	if handler.frame < baseFrame {
+		return false
+	}
+	value, ok := catchableValue(err)
+	if !ok {
+		return false
+	}
+
+	if handler.rethrows {
+		// The calls being abandoned won't be around to add themselves to
+		// the trace when the exception is rethrown
+		vm.traceFrames(err, handler.frame+1, false)
+		value = &vmException{err: err}
+	}
+
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(handler.stackTop)
+	for vm.stackTop > handler.stackTop {
+		vm.pop()
+	}
+	vm.frames = vm.frames[:handler.frame+1]
+	vm.frames[handler.frame].ip = handler.ip
+	vm.push(value)
+	return true
+}
+
+// Executes instructions from the top frame until the frame at baseFrame
+// returns or an exception is thrown.
+func (vm *VM) execute(baseFrame int) (Value, RuntimeException) {
+	frame := vm.frames[len(vm.frames)-1]
+	chunk := frame.closure.proto.chunk
+
+	for {
+		op := OpCode(chunk.code[frame.ip])
+		frame.ip++
+
+		switch op {
+		case OpConstant:
+			index := chunk.readShort(frame.ip)
+			frame.ip += 2
+			vm.push(chunk.constants[index])
+		case OpNil:
+			vm.push(NewNil())
+		case OpTrue:
+			vm.push(NewBool(true))
+		case OpFalse:
+			vm.push(NewBool(false))
+		case OpUninitialized:
+			vm.push(nil)
+		case OpPop:
+			vm.pop()
+		case OpPick:
+			distance := int(chunk.code[frame.ip])
+			frame.ip++
+			vm.push(vm.peek(distance))
+		case OpBury:
+			// Moves the top value down below the given number of values
+			distance := int(chunk.code[frame.ip])
+			frame.ip++
+			value := vm.peek(0)
+			top := vm.stackTop - 1
+			copy(vm.stack[top-distance+1:top+1], vm.stack[top-distance:top])
+			vm.stack[top-distance] = value
+		case OpGetLocal:
+			slot := int(chunk.code[frame.ip])
+			frame.ip++
+			value := vm.stack[frame.slots+slot]
+			if value == nil {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"using uninitialized variable '%s'",
+					chunk.names[frame.ip-2],
+				))
+			}
+			vm.push(value)
+		case OpSetLocal:
+			slot := int(chunk.code[frame.ip])
+			frame.ip++
+			vm.stack[frame.slots+slot] = vm.peek(0)
+		case OpGetUpvalue:
+			index := int(chunk.code[frame.ip])
+			frame.ip++
+			value := *frame.closure.upvalues[index].location
+			if value == nil {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"using uninitialized variable '%s'",
+					chunk.names[frame.ip-2],
+				))
+			}
+			vm.push(value)
+		case OpSetUpvalue:
+			index := int(chunk.code[frame.ip])
+			frame.ip++
+			*frame.closure.upvalues[index].location = vm.peek(0)
+		case OpDefineGlobal:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			frame.closure.module.globals[name] = vm.pop()
+		case OpGetGlobal:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			value, ok := frame.closure.module.globals[name]
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"using undeclared variable '%s'",
+					name,
+				))
+			}
+			if value == nil {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"using uninitialized variable '%s'",
+					name,
+				))
+			}
+			vm.push(value)
+		case OpSetGlobal:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			frame.closure.module.globals[name] = vm.peek(0)
+		case OpGetProperty:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			inst, ok := vm.peek(0).(Fielder)
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"%v values have no properties",
+					vm.peek(0).Type(),
+				))
+			}
+			value, err := inst.Get(vm.token(name))
+			if err != nil {
+				return nil, err
+			}
+			vm.pop()
+			called, err := vm.pushProperty(value)
+			if err != nil {
+				return nil, err
+			}
+			if called {
+				frame = vm.frames[len(vm.frames)-1]
+				chunk = frame.closure.proto.chunk
+			}
+		case OpSetProperty:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			inst, ok := vm.peek(1).(Fielder)
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"%v values have no properties",
+					vm.peek(1).Type(),
+				))
+			}
+			value := vm.pop()
+			err := inst.Set(vm.token(name), value)
+			if err != nil {
+				return nil, err
+			}
+			vm.pop()
+			vm.push(value)
+		case OpGetSuper:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			superclass := vm.pop().(*Class)
+			object := vm.pop().(*Instance)
+			method, err := object.MethodAtClass(vm.token(name), superclass)
+			if err != nil {
+				return nil, err
+			}
+			called, err := vm.pushProperty(method)
+			if err != nil {
+				return nil, err
+			}
+			if called {
+				frame = vm.frames[len(vm.frames)-1]
+				chunk = frame.closure.proto.chunk
+			}
+		case OpEqual:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(NewBool(left.Equal(right)))
+		case OpNotEqual:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(NewBool(!left.Equal(right)))
+		case OpAdd,
+			OpSubtract,
+			OpMultiply,
+			OpDivide,
+			OpModulo,
+			OpFloorDivide,
+			OpPower,
+			OpBitAnd,
+			OpBitOr,
+			OpBitXor,
+			OpShiftLeft,
+			OpShiftRight,
+			OpGreater,
+			OpGreaterEqual,
+			OpLess,
+			OpLessEqual:
+
+			operator := &vmOperators[op]
+			result, message := arithmetic(operator.ty, operator.lexeme, vm.peek(1), vm.peek(0))
+			if message != "" {
+				return nil, vm.runtimeError(message)
+			}
+			vm.pop()
+			vm.pop()
+			vm.push(result)
+		case OpNot:
+			vm.push(NewBool(!vm.pop().Bool()))
+		case OpNegate, OpBitNot:
+			operator := &vmOperators[op]
+			result, message := unaryArithmetic(operator.ty, operator.lexeme, vm.peek(0))
+			if message != "" {
+				return nil, vm.runtimeError(message)
+			}
+			vm.pop()
+			vm.push(result)
+		case OpPrint:
+			fmt.Fprintln(vm.streams.stdout, vm.pop().String())
+		case OpJump:
+			offset := chunk.readShort(frame.ip)
+			frame.ip += 2 + offset
+		case OpJumpIfFalse:
+			offset := chunk.readShort(frame.ip)
+			frame.ip += 2
+			if !vm.peek(0).Bool() {
+				frame.ip += offset
+			}
+		case OpJumpIfPassed:
+			slot := int(chunk.code[frame.ip])
+			offset := chunk.readShort(frame.ip + 1)
+			frame.ip += 3
+			if vm.stack[frame.slots+slot] != nil {
+				frame.ip += offset
+			}
+		case OpLoop:
+			offset := chunk.readShort(frame.ip)
+			frame.ip += 2 - offset
+		case OpIter:
+			pairs := chunk.code[frame.ip] == 2
+			frame.ip++
+			iterable := vm.pop()
+			if iterator, ok := builtinIterator(iterable, pairs); ok {
+				vm.push(iterator)
+			} else if inst, ok := iterable.(Fielder); ok {
+				iter, err := inst.Get(vm.token("iter"))
+				if err != nil {
+					return nil, err
+				}
+				vm.push(iter)
+				err = vm.callValue(iter, 0)
+				if err != nil {
+					return nil, err
+				}
+				frame = vm.frames[len(vm.frames)-1]
+				chunk = frame.closure.proto.chunk
+			} else {
+				return nil, notIterableError(vm.token("iter"), iterable)
+			}
+		case OpUnpackPair:
+			key, value, err := unpackPair(vm.token(""), vm.pop())
+			if err != nil {
+				return nil, err
+			}
+			vm.push(key)
+			vm.push(value)
+		case OpCall:
+			argCount := int(chunk.code[frame.ip])
+			frame.ip++
+			err := vm.callValue(vm.peek(argCount), argCount)
+			if err != nil {
+				return nil, err
+			}
+			frame = vm.frames[len(vm.frames)-1]
+			chunk = frame.closure.proto.chunk
+		case OpCallNamed:
+			argCount := int(chunk.code[frame.ip])
+			names := chunk.constants[chunk.readShort(frame.ip+1)].(*List)
+			frame.ip += 3
+			err := vm.callNamed(argCount, names)
+			if err != nil {
+				return nil, err
+			}
+			frame = vm.frames[len(vm.frames)-1]
+			chunk = frame.closure.proto.chunk
+		case OpClosure:
+			proto := chunk.constants[chunk.readShort(frame.ip)].(*FnProto)
+			frame.ip += 2
+			closure := NewClosure(proto)
+			closure.module = frame.closure.module
+			for i := range closure.upvalues {
+				isLocal := chunk.code[frame.ip]
+				index := int(chunk.code[frame.ip+1])
+				frame.ip += 2
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case OpCloseUpvalue:
+			vm.closeUpvalues(vm.stackTop - 1)
+			vm.pop()
+		case OpImport:
+			path := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			module, err := vm.modules.load(vm.token("import"), frame.closure.module.dir, path, vm.runModule)
+			if err != nil {
+				return nil, err
+			}
+			vm.push(module)
+		case OpTry, OpTryFinally:
+			offset := chunk.readShort(frame.ip)
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, vmHandler{
+				frame:    len(vm.frames) - 1,
+				ip:       frame.ip + offset,
+				stackTop: vm.stackTop,
+				rethrows: op == OpTryFinally,
+			})
+		case OpPopHandler:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case OpThrow:
+			return nil, NewThrowException(vm.token(""), vm.pop())
+		case OpRethrow:
+			return nil, vm.pop().(*vmException).err
+		case OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			for vm.stackTop > frame.slots {
+				vm.pop()
+			}
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == baseFrame {
+				return result, nil
+			}
+			vm.push(result)
+			frame = vm.frames[len(vm.frames)-1]
+			chunk = frame.closure.proto.chunk
+		case OpClass:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			hasSuperclass := chunk.code[frame.ip+2] == 1
+			frame.ip += 3
+
+			var superclass **Class = nil
+			var supermetaclass **Class = nil
+			if hasSuperclass {
+				super, ok := vm.pop().(*Class)
+				if !ok {
+					return nil, vm.runtimeError("superclass must be a class")
+				}
+				superclass = &super
+				tmpcls := super.Class()
+				supermetaclass = &tmpcls
+			}
+
+			metaclass := NewClass(nil, name+" metaclass", supermetaclass, map[string]Method{})
+			vm.push(NewClass(&metaclass, name, superclass, map[string]Method{}))
+		case OpMetaclass:
+			vm.push(vm.pop().(*Class).Class())
+		case OpMethod, OpClassMethod:
+			name := chunk.constants[chunk.readShort(frame.ip)].(String).value
+			frame.ip += 2
+			class := vm.peek(1).(*Class)
+			if op == OpClassMethod {
+				class = class.Class()
+			}
+			class.methods[name] = vm.pop().(*Closure)
+		case OpConcat:
+			part := vm.pop()
+			vm.push(NewString(vm.pop().String() + part.String()))
+		case OpList:
+			vm.push(NewList([]Value{}))
+		case OpListAppend:
+			element := vm.pop()
+			vm.peek(0).(*List).Append(element)
+		case OpMap:
+			vm.push(NewMap())
+		case OpMapInsert:
+			value := vm.pop()
+			key := vm.pop()
+			if !vm.peek(0).(*Map).Insert(key, value) {
+				return nil, unhashableKeyError(vm.token("{"), key)
+			}
+		case OpGetIndex:
+			indexer, ok := vm.peek(1).(Indexer)
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"%v values cannot be indexed",
+					vm.peek(1).Type(),
+				))
+			}
+			value, err := indexer.GetIndex(vm.token("["), vm.peek(0))
+			if err != nil {
+				return nil, err
+			}
+			vm.pop()
+			vm.pop()
+			vm.push(value)
+		case OpSetIndex:
+			indexer, ok := vm.peek(2).(Indexer)
+			if !ok {
+				return nil, vm.runtimeError(fmt.Sprintf(
+					"%v values cannot be indexed",
+					vm.peek(2).Type(),
+				))
+			}
+			value := vm.peek(0)
+			err := indexer.SetIndex(vm.token("["), vm.peek(1), value)
+			if err != nil {
+				return nil, err
+			}
+			vm.pop()
+			vm.pop()
+			vm.pop()
+			vm.push(value)
+		default:
+			panic(fmt.Sprintf("unknown opcode: %v", op))
+		}
+	}
+}
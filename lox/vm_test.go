@@ -0,0 +1,104 @@
+package lox
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVM(t *testing.T) {
+	// An expression nested this deeply needs more stack slots than a call
+	// used to be given
+	nested := strings.Repeat("(", 300) + "n" + strings.Repeat(" + 1)", 300)
+
+	runScriptTests(t, []scriptTest{
+		{
+			name: "closures",
+			source: `
+				fun counter() {
+					var n = 0;
+					fun incr() { n = n + 1; return n; }
+					fun get() { return n; }
+					return [incr, get];
+				}
+				var c = counter();
+				c[0]();
+				c[0]();
+				print c[1]();
+				var d = counter();
+				d[0]();
+				print c[1]() + d[1]();
+			`,
+			output: "2\n3\n",
+		},
+		{
+			name: "classes",
+			source: `
+				class A {
+					init(x) { this.x = x; }
+					get() { return this.x; }
+				}
+				class B < A {
+					init(x) { super.init(x + 1); }
+					get() { return super.get() * 2; }
+				}
+				var b = B(20);
+				print b.get();
+				print b.init(1) == b;
+				print B;
+				print b;
+			`,
+			output: "42\ntrue\n<class 'B'>\n<instance of class 'B'>\n",
+		},
+		{
+			name: "deeply nested expressions",
+			source: `
+				fun f(n) {
+					if (n == 0) return 0;
+					return f(n - 1) + ` + nested + ` - n;
+				}
+				print f(1000);
+			`,
+			output: "300000\n",
+		},
+		{
+			name: "upvalues while the stack grows",
+			source: `
+				fun outer() {
+					var captured = "before";
+					fun set(value) { captured = value; }
+					fun deep(n) {
+						if (n == 0) {
+							set("after");
+							return;
+						}
+						deep(n - 1);
+					}
+					deep(900);
+					print captured;
+				}
+				outer();
+			`,
+			output: "after\n",
+		},
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	in := NewVMInterpreter()
+	in.SetStdout(&stdout)
+	in.SetStderr(&stderr)
+	in.SetDisassemble(true)
+	if _, err := in.Eval("var x = 1 + 2; print x;"); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "3\n" {
+		t.Errorf("got output %q", stdout.String())
+	}
+	for _, op := range []string{"Constant", "Add", "DefineGlobal", "Print", "Return"} {
+		if !strings.Contains(stderr.String(), op) {
+			t.Errorf("disassembly is missing %s:\n%s", op, stderr.String())
+		}
+	}
+}
@@ -2,104 +2,50 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/apsun/golox/lox"
-	"io/ioutil"
 	"os"
 	"time"
 )
 
+var useVM = flag.Bool("vm", false, "run using the bytecode VM instead of the tree-walking interpreter")
+var disassemble = flag.Bool("disassemble", false, "print the compiled bytecode before running it (implies -vm)")
+
 func clock(args []lox.Value) (lox.Value, lox.RuntimeException) {
 	now := float64(time.Now().UnixNano()) / 1e9
 	return lox.NewNumber(now), nil
 }
 
-func run(source string, env *lox.Environment, allowExpr bool) bool {
-	scanner := lox.NewScanner(source)
-	tokens, errs := scanner.ScanTokens()
-	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-		}
-		return false
-	}
-
-	env.DefineNative("clock", lox.NewNativeFn(0, "clock", clock))
-
-	parser := lox.NewParser(tokens)
-	stmts, errs := parser.ParseStatements()
-	if len(errs) > 0 {
-		if allowExpr {
-			// Try to parse as an expression. If that works, then
-			// print out the result of evaluating the expression
-			// instead of trying to get a full statement. If neither
-			// work, then still show the errors from trying to parse
-			// as a statement.
-			parser := lox.NewParser(tokens)
-			expr, errs := parser.ParseExpression()
-			if len(errs) == 0 {
-				resolver := lox.NewResolver()
-				rerrs := resolver.ResolveExpression(expr)
-				if len(rerrs) > 0 {
-					for _, err := range rerrs {
-						fmt.Fprintf(os.Stderr, "%v\n", err)
-					}
-					return false
-				}
-
-				value, err := expr.Evaluate(env)
-				if err == nil {
-					fmt.Printf("%v\n", value.Repr())
-				} else {
-					fmt.Fprintf(os.Stderr, "%v\n", err)
-					return false
-				}
-				return true
-			}
-		}
-
-		for _, err := range errs {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-		}
-		return false
-	}
-
-	resolver := lox.NewResolver()
-	rerrs := resolver.ResolveStatements(stmts)
-	if len(rerrs) > 0 {
-		for _, err := range rerrs {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-		}
-		return false
-	}
-
-	for _, stmt := range stmts {
-		err := stmt.Execute(env)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			return false
-		}
+func newInterpreter() *lox.Interpreter {
+	var interp *lox.Interpreter
+	if *useVM {
+		interp = lox.NewVMInterpreter()
+		interp.SetDisassemble(*disassemble)
+	} else {
+		interp = lox.NewInterpreter()
 	}
-
-	return true
+	interp.SetGlobal("clock", lox.NewNativeFn(0, "clock", clock))
+	return interp
 }
 
 func runFile(path string) {
-	env := lox.NewEnvironment(nil)
-	content, err := ioutil.ReadFile(path)
+	interp := newInterpreter()
+	err := interp.RunFile(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "file not found: %s\n", path)
-		os.Exit(1)
-	}
-
-	ok := run(string(content), env, false)
-	if !ok {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			fmt.Fprintf(os.Stderr, "file not found: %s\n", path)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(65)
 	}
 }
 
 func runPrompt() {
-	env := lox.NewEnvironment(nil)
+	interp := newInterpreter()
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Fprintf(os.Stderr, "> ")
@@ -107,7 +53,12 @@ func runPrompt() {
 			break
 		}
 		line := scanner.Text()
-		run(line, env, true)
+		value, err := interp.Eval(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		} else if value != nil {
+			fmt.Printf("%v\n", value.Repr())
+		}
 	}
 
 	err := scanner.Err()
@@ -118,11 +69,20 @@ func runPrompt() {
 }
 
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s [file]\n", os.Args[0])
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-vm] [-disassemble] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *disassemble {
+		*useVM = true
+	}
+
+	if flag.NArg() > 1 {
+		flag.Usage()
 		os.Exit(64)
-	} else if len(os.Args) == 2 {
-		runFile(os.Args[1])
+	} else if flag.NArg() == 1 {
+		runFile(flag.Arg(0))
 	} else {
 		runPrompt()
 	}